@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/graph"
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// affectedTargets returns the targets affected by the files changed since
+// the --since revision, in the order they are declared. Targets are affected
+// if they own a changed file or depend on an affected target. Only the named
+// targets and their dependencies are considered, or all targets if names is
+// empty, and TARGET groups, services and internal targets that were not
+// named are left out since they run as dependencies.
+func affectedTargets(list parser.Targets, names []string) ([]string, error) {
+	files, err := changedFiles(opts.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	affected := map[string]bool{}
+	for _, t := range list {
+		for _, file := range files {
+			if owns(t, file) {
+				affected[t.Name] = true
+				break
+			}
+		}
+	}
+	for more := true; more; {
+		more = false
+		for _, t := range list {
+			if affected[t.Name] {
+				continue
+			}
+			for _, dep := range append([]string{graph.Parent(t)}, t.Deps...) {
+				if affected[dep] {
+					affected[t.Name] = true
+					more = true
+					break
+				}
+			}
+		}
+	}
+
+	named := map[string]bool{}
+	candidates := list.Sorted()
+	if len(names) > 0 {
+		for _, name := range names {
+			named[name] = true
+		}
+		if candidates, err = graph.ExecOrder(list, names); err != nil {
+			return nil, err
+		}
+	}
+	out := []string{}
+	for _, t := range candidates {
+		if affected[t.Name] && !t.Phony && !t.Service && (!t.Internal || named[t.Name]) {
+			out = append(out, t.Name)
+		}
+	}
+	log.Printf("%d file(s) changed since %s, affecting %d target(s)\n", len(files), opts.Since, len(out))
+	return out, nil
+}
+
+// changedFiles returns the files of the project directory that changed
+// since the merge base of rev and HEAD, including uncommitted and untracked
+// files, as slash separated paths relative to the project directory.
+func changedFiles(rev string) ([]string, error) {
+	git := func(args ...string) ([]string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = origdir
+		out, err := cmd.Output()
+		if err != nil {
+			if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+				err = fmt.Errorf("%s", strings.TrimSpace(string(ee.Stderr)))
+			}
+			return nil, fmt.Errorf("git %s failed: %v", args[0], err)
+		}
+		return strings.Fields(string(out)), nil
+	}
+
+	base, err := git("merge-base", rev, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if len(base) == 0 {
+		return nil, fmt.Errorf("%s has no common ancestor with HEAD", rev)
+	}
+	files, err := git("diff", "--name-only", "--relative", base[0])
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, file := range append(files, untracked...) {
+		// The files drmake keeps in .drmake do not affect targets.
+		if !strings.HasPrefix(file, ".drmake/") {
+			out = append(out, file)
+		}
+	}
+	return out, nil
+}
+
+// owns returns whether the target owns file, a slash separated path relative
+// to the project directory: the file matches one of its SOURCES or ENVFILE
+// paths, is below the directory of its FROM ./path image, or is the build
+// file that declares it.
+func owns(t *parser.Target, file string) bool {
+	rel := func(name string) string {
+		if abs, err := filepath.Abs(name); err == nil {
+			if r, err := filepath.Rel(origdir, abs); err == nil {
+				return filepath.ToSlash(r)
+			}
+		}
+		return filepath.ToSlash(name)
+	}
+
+	// Paths are relative to the directory of the target's SUBDIR project.
+	dir := origdir
+	if t.Dir != "" {
+		dir = t.Dir
+	}
+	patterns := []string{}
+	for _, p := range append(append([]string{}, t.Sources...), t.EnvFiles...) {
+		patterns = append(patterns, rel(filepath.Join(dir, filepath.FromSlash(p))))
+	}
+	if strings.HasPrefix(t.Image, "./") {
+		patterns = append(patterns, rel(t.ImageDir(origdir)))
+	}
+	if t.File != "" {
+		patterns = append(patterns, rel(t.File))
+	}
+	for _, pattern := range patterns {
+		pattern = path.Clean(filepath.ToSlash(pattern))
+		// A pattern matching a directory owns every file below it.
+		for p := file; ; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if p == "." || p == "/" {
+				break
+			}
+		}
+	}
+	return false
+}
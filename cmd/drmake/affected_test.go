@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+func TestOwns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drmake-owns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { origdir = dir }(origdir)
+	origdir = dir
+
+	tests := []struct {
+		name   string
+		target parser.Target
+		file   string
+		want   bool
+	}{
+		{"source", parser.Target{Sources: []string{"go.mod"}}, "go.mod", true},
+		{"source glob", parser.Target{Sources: []string{"src/*.go"}}, "src/main.go", true},
+		{"source directory", parser.Target{Sources: []string{"src"}}, "src/pkg/main.go", true},
+		{"other source", parser.Target{Sources: []string{"src"}}, "docs/index.md", false},
+		{"env file", parser.Target{EnvFiles: []string{".env"}}, ".env", true},
+		{"image directory", parser.Target{Image: "./docker/app"}, "docker/app/Dockerfile", true},
+		{"registry image", parser.Target{Image: "alpine"}, "alpine", false},
+		{"build file", parser.Target{File: filepath.Join(dir, "Makefile.phd")}, "Makefile.phd", true},
+		{"subdir source", parser.Target{Dir: filepath.Join(dir, "api"), Sources: []string{"src"}}, "api/src/main.go", true},
+		{"subdir other source", parser.Target{Dir: filepath.Join(dir, "api"), Sources: []string{"src"}}, "src/main.go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := owns(&tt.target, tt.file); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAffectedTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drmake-affected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v\n%s", args[0], err, out)
+		}
+	}
+	for _, name := range []string{"lib/a.go", "app/main.go", "docs/index.md"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("v1\n"), 0644)
+	}
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+
+	defer func(dir, since string) { origdir, opts.Since = dir, since }(origdir, opts.Since)
+	origdir, opts.Since = dir, "HEAD"
+	list := parser.Targets{
+		"lib":  {Name: "lib", Line: 1, Sources: []string{"lib"}},
+		"app":  {Name: "app", Line: 2, Sources: []string{"app"}, Deps: []string{"lib"}},
+		"docs": {Name: "docs", Line: 3, Sources: []string{"docs"}},
+		"test": {Name: "test", Line: 4, Image: "#app"},
+		"all":  {Name: "all", Line: 5, Phony: true, Deps: []string{"app", "docs"}},
+		"db":   {Name: "db", Line: 6, Service: true, Sources: []string{"lib"}},
+		"gen":  {Name: "gen", Line: 7, Internal: true, Sources: []string{"lib"}},
+	}
+
+	tests := []struct {
+		name    string
+		changed []string
+		names   []string
+		want    []string
+	}{
+		{"nothing changed", nil, nil, []string{}},
+		{"leaf", []string{"docs/index.md"}, nil, []string{"docs"}},
+		{"dependents", []string{"lib/a.go"}, nil, []string{"lib", "app", "test"}},
+		{"parent image", []string{"app/main.go"}, nil, []string{"app", "test"}},
+		{"untracked", []string{"app/new.go"}, nil, []string{"app", "test"}},
+		{"named", []string{"lib/a.go"}, []string{"app"}, []string{"lib", "app"}},
+		{"named internal", []string{"lib/a.go"}, []string{"gen"}, []string{"gen"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range tt.changed {
+				file := filepath.Join(dir, name)
+				ioutil.WriteFile(file, []byte("v2\n"), 0644)
+				defer git("checkout", "-q", "--", ".")
+				defer os.Remove(file)
+			}
+			got, err := affectedTargets(list, tt.names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
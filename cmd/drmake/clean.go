@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type cleanCommand struct {
+	All bool `long:"all" description:"Remove the volumes and images of every drmake project on this machine"`
+}
+
+func init() {
+	argparser.AddCommand("clean", "Remove the project's volumes and images",
+		"Removes the workspace volume, the cache volumes (including CACHE volumes) and the target images of the project. "+
+			"With --all, every volume and image created by drmake is removed instead. "+
+			"Volumes named with --volume-prefix are only removed when the same prefix is given.",
+		&cleanCommand{})
+}
+
+func (c *cleanCommand) Execute(args []string) error {
+	volumes, err := listResources("volume", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return err
+	}
+	images, err := listResources("images", "--format", "{{.Repository}}:{{.Tag}}")
+	if err != nil {
+		return err
+	}
+
+	for _, vol := range volumes {
+		if c.ownsVolume(vol) {
+			log.Printf("Removing volume %s\n", vol)
+			if err := rn.Runtime().Command("volume", "rm", "-f", vol).Run(); err != nil {
+				log.Printf("Failed to remove volume %s: %v\n", vol, err)
+			}
+		}
+	}
+	for _, img := range images {
+		if c.ownsImage(img) {
+			log.Printf("Removing image %s\n", img)
+			if err := rn.Runtime().Command("rmi", "-f", img).Run(); err != nil {
+				log.Printf("Failed to remove image %s: %v\n", img, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ownsVolume returns whether vol should be removed.
+func (c *cleanCommand) ownsVolume(vol string) bool {
+	if c.All && (strings.HasPrefix(vol, "drmake-ws-") || strings.HasPrefix(vol, "drmake-cache-")) {
+		return true
+	}
+	cache := rn.VolumeName("cache")
+	return vol == rn.VolumeName("ws") || vol == cache || strings.HasPrefix(vol, cache+"-") ||
+		vol == rn.LegacyVolumeName("ws") || vol == rn.LegacyVolumeName("cache")
+}
+
+// ownsImage returns whether the repository:tag img should be removed.
+func (c *cleanCommand) ownsImage(img string) bool {
+	// podman prefixes local images with their registry.
+	img = strings.TrimPrefix(img, "localhost/")
+	if c.All {
+		return strings.HasPrefix(img, "drmake-") && strings.Contains(img, "/")
+	}
+	return strings.HasPrefix(img, rn.Image()+"/")
+}
+
+// listResources runs the runtime with args and returns the non-empty lines
+// it prints.
+func listResources(args ...string) ([]string, error) {
+	cmd := rn.Runtime().Command(args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v", rn.Runtime().Name(), strings.Join(args, " "), err)
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
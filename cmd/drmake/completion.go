@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+type completionCommand struct {
+	Targets bool `long:"targets" hidden:"yes" description:"Print target names for use by completion scripts"`
+
+	Args struct {
+		Shell string `positional-arg-name:"SHELL" choice:"bash" choice:"zsh" choice:"fish"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	argparser.AddCommand("completion", "Print a shell completion script",
+		"Prints a completion script for bash, zsh or fish. Target names are completed from the build file in the current directory.\n\n"+
+			"  bash: source <(drmake completion bash)\n"+
+			"  zsh:  source <(drmake completion zsh)\n"+
+			"  fish: drmake completion fish | source",
+		&completionCommand{})
+}
+
+func (c *completionCommand) Execute(args []string) error {
+	if c.Targets {
+		list, _, err := parseMakefile()
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for name, t := range list {
+			if !t.Internal {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		fmt.Println(strings.Join(names, "\n"))
+		return nil
+	}
+
+	switch c.Args.Shell {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		writeZshCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		return fmt.Errorf("completion requires a shell: bash, zsh or fish")
+	}
+	return nil
+}
+
+// completionOptions returns all visible global options.
+func completionOptions() []*flags.Option {
+	options := []*flags.Option{}
+	var walk func(g *flags.Group)
+	walk = func(g *flags.Group) {
+		for _, o := range g.Options() {
+			if !o.Hidden && o.LongName != "" {
+				options = append(options, o)
+			}
+		}
+		for _, sub := range g.Groups() {
+			walk(sub)
+		}
+	}
+	walk(argparser.Command.Group)
+	return options
+}
+
+// completionWords returns the global flags and subcommand names.
+func completionWords() (flagWords, commandWords []string) {
+	for _, o := range completionOptions() {
+		flagWords = append(flagWords, "--"+o.LongName)
+		if o.ShortName != 0 {
+			flagWords = append(flagWords, "-"+string(o.ShortName))
+		}
+	}
+	for _, cmd := range argparser.Commands() {
+		if !cmd.Hidden {
+			commandWords = append(commandWords, cmd.Name)
+		}
+	}
+	return
+}
+
+func writeBashCompletion(w io.Writer) {
+	flagWords, commandWords := completionWords()
+	fmt.Fprintf(w, `_drmake() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "%s $(drmake completion --targets 2>/dev/null)" -- "$cur"))
+	fi
+	declare -F __ltrim_colon_completions >/dev/null && __ltrim_colon_completions "$cur"
+}
+complete -F _drmake drmake
+`, strings.Join(flagWords, " "), strings.Join(commandWords, " "))
+}
+
+func writeZshCompletion(w io.Writer) {
+	flagWords, commandWords := completionWords()
+	fmt.Fprintf(w, `#compdef drmake
+_drmake() {
+	if [[ "$PREFIX" == -* ]]; then
+		compadd -- %s
+	else
+		compadd -- %s ${(f)"$(drmake completion --targets 2>/dev/null)"}
+	fi
+}
+compdef _drmake drmake
+`, strings.Join(flagWords, " "), strings.Join(commandWords, " "))
+}
+
+func writeFishCompletion(w io.Writer) {
+	quote := func(s string) string {
+		return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+	}
+
+	fmt.Fprintln(w, "complete -c drmake -f")
+	fmt.Fprintln(w, "complete -c drmake -a '(drmake completion --targets 2>/dev/null)'")
+	for _, cmd := range argparser.Commands() {
+		if !cmd.Hidden {
+			fmt.Fprintf(w, "complete -c drmake -a %s -d %s\n", quote(cmd.Name), quote(cmd.ShortDescription))
+		}
+	}
+	for _, o := range completionOptions() {
+		line := "complete -c drmake -l " + o.LongName
+		if o.ShortName != 0 {
+			line += " -s " + string(o.ShortName)
+		}
+		if o.Field().Type.Kind() != reflect.Bool {
+			line += " -r"
+		}
+		fmt.Fprintln(w, line+" -d "+quote(o.Description))
+	}
+}
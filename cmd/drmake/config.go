@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configFiles returns the paths of the configuration files in the order
+// they are applied, so that the project's overrides the user's.
+func configFiles() []string {
+	files := []string{}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	if dir != "" {
+		files = append(files, filepath.Join(dir, "drmake", "config.yml"))
+	}
+	return append(files, filepath.Join(".drmake", "config.yml"))
+}
+
+// configArgs returns the command line arguments equivalent to the settings
+// of the configuration files, which are given before the actual arguments
+// so that those take precedence. Settings are named after the long flags
+// they set, and flags lists arguments that are added as they are. The files
+// are merged before they are converted, so that a setting of the project's
+// replaces the same setting of the user's, even if it is false or a shorter
+// list. A setting whose flag is also set by its environment variable is
+// left out.
+func configArgs() ([]string, error) {
+	config := map[string]interface{}{}
+	source := map[string]string{}
+	for _, name := range configFiles() {
+		data, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		file := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		for key, value := range file {
+			config[key], source[key] = value, name
+		}
+	}
+
+	args := []string{}
+	keys := []string{}
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, ok := config[key].([]interface{})
+		if !ok {
+			values = []interface{}{config[key]}
+		}
+		if key == "flags" {
+			for _, v := range values {
+				args = append(args, fmt.Sprint(v))
+			}
+			continue
+		}
+
+		option := argparser.FindOptionByLongName(key)
+		if option == nil {
+			return nil, fmt.Errorf("%s: unknown setting %s", source[key], key)
+		}
+		if option.EnvDefaultKey != "" && os.Getenv(option.EnvDefaultKey) != "" {
+			continue
+		}
+		for _, v := range values {
+			switch v := v.(type) {
+			case bool:
+				if v {
+					args = append(args, "--"+key)
+				}
+			case nil:
+			default:
+				args = append(args, fmt.Sprintf("--%s=%v", key, v))
+			}
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		project string
+		env     map[string]string
+		want    []string
+		err     string
+	}{
+		{"none", "", "", nil, []string{}, ""},
+		{"user", "pull: true\nenv: [A, B]\n", "", nil, []string{"--env=A", "--env=B", "--pull"}, ""},
+		{"project", "", "runtime: podman\nflags: [--keep-going]\n", nil, []string{"--keep-going", "--runtime=podman"}, ""},
+		{"merged", "pull: true\nruntime: podman\n", "keep-going: true\n", nil, []string{"--keep-going", "--pull", "--runtime=podman"}, ""},
+		{"false overrides true", "pull: true\n", "pull: false\n", nil, []string{}, ""},
+		{"list replaces list", "env: [A, B]\n", "env: [C]\n", nil, []string{"--env=C"}, ""},
+		{"environment variable", "runtime: podman\n", "", map[string]string{"DRMAKE_RUNTIME": "docker"}, []string{}, ""},
+		{"unknown setting", "", "colour: true\n", nil, nil, filepath.Join(".drmake", "config.yml") + ": unknown setting colour"},
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "drmake-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			os.MkdirAll(filepath.Join(dir, "home", "drmake"), 0755)
+			os.MkdirAll(filepath.Join(dir, "project", ".drmake"), 0755)
+			if tt.user != "" {
+				ioutil.WriteFile(filepath.Join(dir, "home", "drmake", "config.yml"), []byte(tt.user), 0644)
+			}
+			if tt.project != "" {
+				ioutil.WriteFile(filepath.Join(dir, "project", ".drmake", "config.yml"), []byte(tt.project), 0644)
+			}
+			os.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "home"))
+			os.Chdir(filepath.Join(dir, "project"))
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			got, err := configArgs()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got arguments %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
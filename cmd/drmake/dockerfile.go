@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// printDockerfiles prints the Dockerfiles that the named targets are built
+// from, as passed to the runtime. When printing more than one, each is
+// preceded by a comment naming its target.
+func printDockerfiles(list parser.Targets, names []string) error {
+	for i, name := range names {
+		target, err := list.Find(name)
+		if err != nil {
+			return err
+		}
+		dfile, err := rn.Dockerfile(list, target)
+		if err != nil {
+			return err
+		}
+		if len(names) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# Dockerfile of target %s\n", name)
+		}
+		if target.Phony {
+			fmt.Printf("# target %s has no image\n", name)
+			continue
+		}
+		if dfile == "" {
+			fmt.Printf("# target %s reuses its own image and is not built\n", name)
+			continue
+		}
+		fmt.Print(dfile)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+type exportCommand struct {
+	Args struct {
+		Target string `positional-arg-name:"TARGET" required:"yes"`
+		Dir    string `positional-arg-name:"DIR" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	argparser.AddCommand("export", "Export a target as a Dockerfile and build context",
+		"Writes the generated Dockerfile of the target to DIR, along with the other files of the directories its FROM ./path and FROM &target images come from, so that the image can be built with plain docker build.",
+		&exportCommand{})
+}
+
+func (c *exportCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	target, err := list.Find(c.Args.Target)
+	if err != nil {
+		return err
+	}
+	dfile, err := rn.Dockerfile(list, target)
+	if err != nil {
+		return err
+	}
+	if target.Phony {
+		return fmt.Errorf("target %s has no image", target.Name)
+	}
+	if dfile == "" {
+		return fmt.Errorf("target %s reuses its own image and has no Dockerfile", target.Name)
+	}
+
+	if err := os.MkdirAll(c.Args.Dir, 0755); err != nil {
+		return err
+	}
+	for _, dir := range imageDirs(list, target) {
+		if err := copyContext(dir, c.Args.Dir); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.Args.Dir, "Dockerfile"), []byte(dfile), 0644); err != nil {
+		return err
+	}
+	log.Printf("Exported target %s to %s\n", target.Name, c.Args.Dir)
+	return nil
+}
+
+// imageDirs returns the directories of the FROM ./path and FROM &target
+// images that the target's Dockerfile is generated from, following
+// FROM #target references.
+func imageDirs(list parser.Targets, s *parser.Target) []string {
+	dirs := []string{}
+	seen := map[string]bool{}
+	for s != nil && !seen[s.Name] {
+		seen[s.Name] = true
+		if dir := s.ImageDir(origdir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+		if !strings.HasPrefix(s.Image, "#") {
+			break
+		}
+		s = list[s.Image[1:]]
+	}
+	return dirs
+}
+
+// copyContext copies the files of the image directory src, other than its
+// Dockerfile, into dst.
+func copyContext(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." || rel == "Dockerfile" {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode().Perm())
+	})
+}
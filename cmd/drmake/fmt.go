@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+type fmtCommand struct {
+	Check bool `long:"check" description:"Print the files that are not formatted and fail instead of rewriting them"`
+}
+
+func init() {
+	argparser.AddCommand("fmt", "Format build files",
+		"Rewrites the given build files, or the build file, in canonical form: upper case keywords, a single space after keywords, continued lines indented by four spaces, sorted ARTIFACT lines, a blank line between targets and no trailing whitespace. Comments are kept. With --check, the files are left as they are and the command fails if any of them is not formatted, for use in pre-commit hooks and CI.",
+		&fmtCommand{})
+}
+
+func (c *fmtCommand) Execute(args []string) error {
+	files := args
+	if len(files) == 0 {
+		if isRemote(opts.Makefile) {
+			return fmt.Errorf("%s is a remote build file, which cannot be formatted", opts.Makefile)
+		}
+		if opts.Makefile == "-" {
+			return fmtStdin(c.Check)
+		}
+		files = []string{opts.Makefile}
+	}
+	unformatted := 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		formatted := parser.Format(string(data))
+		if formatted == string(data) {
+			continue
+		}
+		if c.Check {
+			fmt.Println(file)
+			unformatted++
+			continue
+		}
+		if err := ioutil.WriteFile(file, []byte(formatted), 0644); err != nil {
+			return err
+		}
+		log.Printf("Formatted %s\n", file)
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("%d file(s) are not formatted, run drmake fmt", unformatted)
+	}
+	return nil
+}
+
+// fmtStdin prints the build file read from stdin in canonical form, or with
+// check, fails if it is not formatted.
+func fmtStdin(check bool) error {
+	data := buildFiles[filepath.Join(origdir, stdinName)]
+	formatted := parser.Format(data)
+	if !check {
+		fmt.Print(formatted)
+		return nil
+	}
+	if formatted != data {
+		fmt.Println(stdinName)
+		return fmt.Errorf("%s is not formatted, run drmake fmt", stdinName)
+	}
+	return nil
+}
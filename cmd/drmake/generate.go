@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// reJobID matches the characters that are not allowed in GitHub Actions
+// job IDs.
+var reJobID = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// gitlabKeywords are the top-level keys of .gitlab-ci.yml that cannot be
+// used as job names.
+var gitlabKeywords = map[string]bool{
+	"default": true, "include": true, "stages": true, "variables": true, "workflow": true,
+	"image": true, "services": true, "cache": true, "before_script": true, "after_script": true,
+}
+
+type generateCommand struct {
+	Args struct {
+		Format string `positional-arg-name:"FORMAT" required:"yes" choice:"github-actions" choice:"gitlab-ci"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	argparser.AddCommand("generate", "Print a CI pipeline that runs the targets",
+		"Prints a GitHub Actions workflow or a GitLab CI pipeline with a job for every target that is not internal, a service or a TARGET group. Jobs need the jobs of the targets they depend on with USING, so that they run in the same order as with drmake and are skipped when a dependency fails. Since jobs do not share the workspace volume, each job still runs the dependencies of its target.\n\n"+
+			"  drmake generate github-actions > .github/workflows/drmake.yml\n"+
+			"  drmake generate gitlab-ci > .gitlab-ci.yml",
+		&generateCommand{})
+}
+
+func (c *generateCommand) Execute(args []string) error {
+	var write func(io.Writer, []*parser.Target, map[string][]string)
+	switch c.Args.Format {
+	case "github-actions":
+		write = writeGitHubActions
+	case "gitlab-ci":
+		write = writeGitLabCI
+	default:
+		return fmt.Errorf("Unknown CI format %s, expected github-actions or gitlab-ci", c.Args.Format)
+	}
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	jobs, needs := ciJobs(list)
+	if len(jobs) == 0 {
+		return fmt.Errorf("%s has no targets to run in CI", opts.Makefile)
+	}
+	write(os.Stdout, jobs, needs)
+	return nil
+}
+
+// ciJobs returns the targets that become CI jobs, in the order they are
+// declared, and the jobs each of them needs. Dependencies that are not jobs
+// themselves are replaced by the jobs they depend on.
+func ciJobs(list parser.Targets) ([]*parser.Target, map[string][]string) {
+	jobs := []*parser.Target{}
+	isJob := map[string]bool{}
+	for _, t := range list.Sorted() {
+		if !t.Internal && !t.Service && !t.Phony {
+			jobs = append(jobs, t)
+			isJob[t.Name] = true
+		}
+	}
+
+	needs := map[string][]string{}
+	for _, job := range jobs {
+		seen := map[string]bool{}
+		var add func(deps []string)
+		add = func(deps []string) {
+			for _, dep := range deps {
+				if seen[dep] || list[dep] == nil {
+					continue
+				}
+				seen[dep] = true
+				if isJob[dep] {
+					needs[job.Name] = append(needs[job.Name], dep)
+				} else {
+					add(list[dep].Deps)
+				}
+			}
+		}
+		add(job.Deps)
+	}
+	return jobs, needs
+}
+
+// writeGitHubActions writes a workflow that runs on pushes and pull
+// requests, with a job per target that installs drmake and runs it.
+func writeGitHubActions(w io.Writer, jobs []*parser.Target, needs map[string][]string) {
+	id := func(name string) string {
+		id := reJobID.ReplaceAllString(name, "_")
+		if id[0] >= '0' && id[0] <= '9' || id[0] == '-' {
+			id = "_" + id
+		}
+		return id
+	}
+
+	fmt.Fprintf(w, "# Generated by drmake generate github-actions from %s.\n", opts.Makefile)
+	fmt.Fprintln(w, "name: drmake")
+	fmt.Fprintln(w, "on:")
+	fmt.Fprintln(w, "  push:")
+	fmt.Fprintln(w, "  pull_request:")
+	fmt.Fprintln(w, "jobs:")
+	for _, job := range jobs {
+		fmt.Fprintf(w, "  %s:\n", id(job.Name))
+		fmt.Fprintf(w, "    name: %q\n", job.Name)
+		fmt.Fprintln(w, "    runs-on: ubuntu-latest")
+		if len(needs[job.Name]) > 0 {
+			ids := []string{}
+			for _, need := range needs[job.Name] {
+				ids = append(ids, id(need))
+			}
+			fmt.Fprintf(w, "    needs: [%s]\n", strings.Join(ids, ", "))
+		}
+		fmt.Fprintln(w, "    steps:")
+		fmt.Fprintln(w, "      - uses: actions/checkout@v4")
+		fmt.Fprintln(w, "      - uses: actions/setup-go@v5")
+		fmt.Fprintln(w, "        with:")
+		fmt.Fprintln(w, "          go-version: stable")
+		fmt.Fprintln(w, "      - run: go install github.com/lsegal/drmake/cmd/drmake@latest")
+		fmt.Fprintf(w, "      - run: drmake -f %s %s\n", opts.Makefile, job.Name)
+	}
+}
+
+// writeGitLabCI writes a pipeline with a job per target that runs drmake
+// against a docker:dind service.
+func writeGitLabCI(w io.Writer, jobs []*parser.Target, needs map[string][]string) {
+	name := func(name string) string {
+		if gitlabKeywords[name] {
+			name = "drmake-" + name
+		}
+		return fmt.Sprintf("%q", name)
+	}
+
+	fmt.Fprintf(w, "# Generated by drmake generate gitlab-ci from %s.\n", opts.Makefile)
+	fmt.Fprintln(w, "default:")
+	fmt.Fprintln(w, "  image: docker:27")
+	fmt.Fprintln(w, "  services:")
+	fmt.Fprintln(w, "    - docker:27-dind")
+	fmt.Fprintln(w, "  before_script:")
+	fmt.Fprintln(w, "    - apk add --no-cache go")
+	fmt.Fprintln(w, "    - go install github.com/lsegal/drmake/cmd/drmake@latest")
+	fmt.Fprintln(w, "    - export PATH=\"$PATH:$(go env GOPATH)/bin\"")
+	fmt.Fprintln(w, "variables:")
+	fmt.Fprintln(w, "  DOCKER_HOST: tcp://docker:2375")
+	fmt.Fprintln(w, "  DOCKER_TLS_CERTDIR: \"\"")
+	for _, job := range jobs {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s:\n", name(job.Name))
+		ids := []string{}
+		for _, need := range needs[job.Name] {
+			ids = append(ids, name(need))
+		}
+		fmt.Fprintf(w, "  needs: [%s]\n", strings.Join(ids, ", "))
+		fmt.Fprintln(w, "  script:")
+		fmt.Fprintf(w, "    - drmake -f %s %s\n", opts.Makefile, job.Name)
+	}
+}
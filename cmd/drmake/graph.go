@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"sort"
+
+	"github.com/lsegal/drmake/pkg/graph"
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+type graphCommand struct {
+	Format string `long:"format" value-name:"FORMAT" default:"dot" choice:"dot" choice:"mermaid" description:"The graph output format"`
+}
+
+func init() {
+	argparser.AddCommand("graph", "Print the target dependency graph",
+		"Prints the dependency graph of all targets (or only the given targets and their dependencies) in Graphviz DOT or Mermaid format.",
+		&graphCommand{})
+}
+
+func (c *graphCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+
+	targets := []*parser.Target{}
+	if len(args) > 0 {
+		order, err := graph.ExecOrder(list, args)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, t := range order {
+			for ; t != nil && !seen[t.Name]; t = list[graph.Parent(t)] {
+				seen[t.Name] = true
+				targets = append(targets, t)
+			}
+		}
+	} else {
+		for _, t := range list {
+			targets = append(targets, t)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+
+	switch c.Format {
+	case "mermaid":
+		graph.WriteMermaid(os.Stdout, targets)
+	default:
+		graph.WriteDot(os.Stdout, targets)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type importCommand struct{}
+
+type importComposeCommand struct {
+	Output string `short:"o" long:"output" value-name:"FILE" description:"The build file to write (default: the compose file's name with a .phd extension)"`
+
+	Args struct {
+		File string `positional-arg-name:"COMPOSE_FILE"`
+	} `positional-args:"yes"`
+}
+
+// composeFile is the part of a docker-compose.yml that is imported. Fields
+// that compose accepts in several forms are decoded as interface{}.
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image       string              `yaml:"image"`
+	Build       interface{}         `yaml:"build"`
+	Command     interface{}         `yaml:"command"`
+	Entrypoint  interface{}         `yaml:"entrypoint"`
+	WorkingDir  string              `yaml:"working_dir"`
+	Environment interface{}         `yaml:"environment"`
+	EnvFile     interface{}         `yaml:"env_file"`
+	Volumes     []interface{}       `yaml:"volumes"`
+	Ports       []interface{}       `yaml:"ports"`
+	DependsOn   interface{}         `yaml:"depends_on"`
+	Healthcheck *composeHealthcheck `yaml:"healthcheck"`
+}
+
+type composeHealthcheck struct {
+	Test     interface{} `yaml:"test"`
+	Interval string      `yaml:"interval"`
+	Timeout  string      `yaml:"timeout"`
+	Retries  int         `yaml:"retries"`
+}
+
+func init() {
+	cmd, _ := argparser.AddCommand("import", "Import targets from other build tools",
+		"Converts the configuration of another build tool into a build file of drmake targets.",
+		&importCommand{})
+	cmd.AddCommand("compose", "Import the services of a docker-compose.yml",
+		"Writes a build file with a target for every service of COMPOSE_FILE (compose.yaml or docker-compose.yml by default). Services that other services depend on, or that only name an image, become SERVICE targets and services that are built from a directory become FROM targets that run with their dependencies. Volumes, ports, environment variables, env files, commands and healthchecks are converted to the equivalent directives. Include the generated file from Makefile.phd with INCLUDE.",
+		&importComposeCommand{})
+}
+
+// composeFileNames are the compose files that are imported by default, in
+// the order compose looks for them.
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+func (c *importComposeCommand) Execute(args []string) error {
+	if c.Args.File == "" {
+		c.Args.File = composeFileNames[len(composeFileNames)-1]
+		for _, name := range composeFileNames {
+			if _, err := os.Stat(name); err == nil {
+				c.Args.File = name
+				break
+			}
+		}
+	}
+	data, err := ioutil.ReadFile(c.Args.File)
+	if err != nil {
+		return err
+	}
+	var compose composeFile
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return fmt.Errorf("Failed to parse %s: %v", c.Args.File, err)
+	}
+	if len(compose.Services) == 0 {
+		return fmt.Errorf("%s has no services", c.Args.File)
+	}
+
+	out := c.Output
+	if out == "" {
+		out = strings.TrimSuffix(c.Args.File, filepath.Ext(c.Args.File)) + ".phd"
+	}
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("%s already exists", out)
+	}
+	phd, err := composeTargets(compose, filepath.Dir(c.Args.File))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(out, phd, 0644); err != nil {
+		return err
+	}
+	log.Printf("Imported %d service(s) from %s to %s, add INCLUDE %s to %s to use them\n",
+		len(compose.Services), c.Args.File, out, filepath.ToSlash(out), opts.Makefile)
+	return nil
+}
+
+// composeTargets returns a build file with a target for every service of
+// the compose file, in order of their names. Relative paths in the compose
+// file are resolved from composeDir and, like all paths of build files,
+// written relative to the project directory.
+func composeTargets(compose composeFile, composeDir string) ([]byte, error) {
+	dependedOn := map[string]bool{}
+	names := []string{}
+	for name, svc := range compose.Services {
+		names = append(names, name)
+		for _, dep := range composeList(svc.DependsOn) {
+			dependedOn[dep] = true
+		}
+	}
+	sort.Strings(names)
+
+	relpath := func(path string) string {
+		if filepath.IsAbs(path) {
+			return filepath.ToSlash(path)
+		}
+		path = filepath.Join(composeDir, path)
+		if abs, err := filepath.Abs(path); err == nil {
+			if rel, err := filepath.Rel(origdir, abs); err == nil {
+				path = rel
+			}
+		}
+		if path == "." {
+			return "./"
+		}
+		return "./" + filepath.ToSlash(path)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		svc := compose.Services[name]
+		lines, err := composeTarget(name, svc, dependedOn[name], relpath)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// composeTarget returns the lines of the target for the compose
+// service name. relpath converts a path of the compose file into a path of
+// the build file.
+func composeTarget(name string, svc composeService, dependedOn bool, relpath func(string) string) ([]string, error) {
+	lines := []string{}
+	keyword, image := "SERVICE", svc.Image
+	if svc.Build != nil {
+		context, dockerfile, buildArgs := composeBuild(svc.Build)
+		if dockerfile != "" && dockerfile != "Dockerfile" {
+			lines = append(lines, fmt.Sprintf("# TODO: FROM ./path requires a file named Dockerfile, rename %s", dockerfile))
+		}
+		if len(buildArgs) > 0 {
+			lines = append(lines, "# TODO: pass these build args with -a: "+strings.Join(buildArgs, " "))
+		}
+		image = relpath(context)
+		if !dependedOn {
+			keyword = "FROM"
+		}
+	}
+	if image == "" {
+		return nil, fmt.Errorf("no image or build")
+	}
+
+	from := fmt.Sprintf("%s %s AS %s", keyword, image, name)
+	if deps := composeList(svc.DependsOn); len(deps) > 0 {
+		from += " USING " + strings.Join(deps, " ")
+	}
+	lines = append(lines, from)
+
+	for _, v := range composeList(svc.Environment) {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			lines = append(lines, "ENV "+kv[0]+"="+dockerfileValue(kv[1]))
+		} else {
+			lines = append(lines, "PASSENV "+v)
+		}
+	}
+	for _, file := range composeList(svc.EnvFile) {
+		lines = append(lines, "ENVFILE "+relpath(file))
+	}
+	for _, v := range svc.Volumes {
+		if line := composeVolume(v, relpath); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	for _, p := range svc.Ports {
+		lines = append(lines, "PORT "+composePort(p))
+	}
+	if svc.WorkingDir != "" {
+		lines = append(lines, "WORKDIR "+svc.WorkingDir)
+	}
+	if svc.Healthcheck != nil {
+		if line := composeHealthcheckLine(svc.Healthcheck); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if svc.Entrypoint != nil {
+		lines = append(lines, "ENTRYPOINT "+composeCommand(svc.Entrypoint))
+	}
+	if svc.Command != nil {
+		lines = append(lines, "CMD "+composeCommand(svc.Command))
+	}
+	return lines, nil
+}
+
+// composeList returns a compose field that is either a string, a list or a
+// map as a list. Maps become KEY=value entries, or only their keys where
+// the value is empty or a map, as with the long form of depends_on.
+func composeList(v interface{}) []string {
+	list := []string{}
+	switch v := v.(type) {
+	case string:
+		list = append(list, v)
+	case []interface{}:
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			switch value.(type) {
+			case map[interface{}]interface{}, nil:
+				list = append(list, fmt.Sprint(key))
+			default:
+				list = append(list, fmt.Sprintf("%v=%v", key, value))
+			}
+		}
+		sort.Strings(list)
+	}
+	return list
+}
+
+// composeBuild returns the context, Dockerfile and build args of a build
+// field, which is either the context or a map.
+func composeBuild(v interface{}) (context, dockerfile string, args []string) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Sprint(v), "", nil
+	}
+	context = "."
+	if c, ok := m["context"]; ok {
+		context = fmt.Sprint(c)
+	}
+	if d, ok := m["dockerfile"]; ok {
+		dockerfile = fmt.Sprint(d)
+	}
+	return context, dockerfile, composeList(m["args"])
+}
+
+// composeVolume returns the MOUNT for a bind mount and the CACHE for a
+// named volume. Anonymous volumes are not kept by drmake and are left out.
+func composeVolume(v interface{}, relpath func(string) string) string {
+	var source, target string
+	readOnly := false
+	switch v := v.(type) {
+	case string:
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 {
+			return ""
+		}
+		source, target = parts[0], parts[1]
+		readOnly = len(parts) > 2 && strings.Contains(parts[2], "ro")
+	case map[interface{}]interface{}:
+		source, target = fmt.Sprint(v["source"]), fmt.Sprint(v["target"])
+		if v["source"] == nil || v["type"] == "tmpfs" {
+			return ""
+		}
+		readOnly = v["read_only"] == true
+	default:
+		return ""
+	}
+	if !strings.HasPrefix(source, ".") && !strings.HasPrefix(source, "/") && !strings.HasPrefix(source, "~") {
+		return "CACHE " + target
+	}
+	if !strings.HasPrefix(source, "~") {
+		source = relpath(source)
+	}
+	line := "MOUNT " + source + ":" + target
+	if readOnly {
+		line += ":ro"
+	}
+	return line
+}
+
+// composePort returns a port in the short form, converting the long form
+// of published and target ports.
+func composePort(v interface{}) string {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	port := fmt.Sprint(m["target"])
+	if m["published"] != nil {
+		port = fmt.Sprintf("%v:%s", m["published"], port)
+	}
+	if m["host_ip"] != nil {
+		port = fmt.Sprintf("%v:%s", m["host_ip"], port)
+	}
+	if m["protocol"] != nil {
+		port += fmt.Sprintf("/%v", m["protocol"])
+	}
+	return port
+}
+
+// composeHealthcheckLine returns the HEALTHCHECK instruction of a compose
+// healthcheck, whose test is a command string or a list starting with CMD,
+// CMD-SHELL or NONE.
+func composeHealthcheckLine(h *composeHealthcheck) string {
+	var cmd string
+	switch test := h.Test.(type) {
+	case string:
+		cmd = test
+	case []interface{}:
+		if len(test) == 0 {
+			return ""
+		}
+		switch fmt.Sprint(test[0]) {
+		case "NONE":
+			return "HEALTHCHECK NONE"
+		case "CMD-SHELL":
+			cmd = strings.Join(composeList(test[1:]), " ")
+		case "CMD":
+			cmd = composeCommand(test[1:])
+		default:
+			cmd = composeCommand(test)
+		}
+	default:
+		return ""
+	}
+	line := "HEALTHCHECK"
+	if h.Interval != "" {
+		line += " --interval=" + h.Interval
+	}
+	if h.Timeout != "" {
+		line += " --timeout=" + h.Timeout
+	}
+	if h.Retries > 0 {
+		line += fmt.Sprintf(" --retries=%d", h.Retries)
+	}
+	return line + " CMD " + cmd
+}
+
+// composeCommand returns a command string as-is and a command list in the
+// exec form of Dockerfile instructions.
+func composeCommand(v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	data, _ := json.Marshal(composeList(list))
+	return string(data)
+}
+
+// dockerfileValue quotes the value of an ENV instruction if it is empty or
+// contains whitespace or quotes.
+func dockerfileValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\"'\\") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+type lintCommand struct{}
+
+func init() {
+	argparser.AddCommand("lint", "Check the build file for mistakes",
+		"Checks the build file for unknown instructions, duplicate targets, dependencies on unknown targets, unused targets and artifacts copied outside of the project directory. Exits with a non-zero status if any problems are found.",
+		&lintCommand{})
+}
+
+func (c *lintCommand) Execute(args []string) error {
+	err := parser.Lint(makefile, parser.Options{Args: opts.Args, Dir: origdir, Strict: opts.Strict, Files: buildFiles})
+	if errs, ok := err.(parser.ErrorList); ok {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		return fmt.Errorf("Found %d problem(s) in %s", len(errs), opts.Makefile)
+	}
+	return err
+}
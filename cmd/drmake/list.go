@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// targetInfo is the structured representation of a target printed by
+// --list with --format json or yaml.
+type targetInfo struct {
+	Name         string            `json:"name" yaml:"name"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Image        string            `json:"image" yaml:"image"`
+	Dependencies []string          `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+	Artifacts    map[string]string `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+	Service      bool              `json:"service,omitempty" yaml:"service,omitempty"`
+	Category     string            `json:"category,omitempty" yaml:"category,omitempty"`
+	Internal     bool              `json:"internal,omitempty" yaml:"internal,omitempty"`
+	Namespace    string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+// listed returns whether --list shows the target. Internal targets are
+// only shown with --all, and --category and --namespace limit the listing
+// to a category and a namespace.
+func listed(t *parser.Target) bool {
+	if opts.Category != "" && !strings.EqualFold(t.Category, opts.Category) {
+		return false
+	}
+	if opts.Namespace != "" && t.Namespace != strings.TrimSuffix(opts.Namespace, ":") {
+		return false
+	}
+	return opts.ListAll || !t.Internal
+}
+
+// printList prints the names and descriptions of the targets, grouped by
+// namespace, with the targets of the main project first, and then by
+// category in the order the categories first appear in the build file.
+// Targets without a category come first. Targets without a description are
+// only shown with --all.
+func printList(list parser.Targets) {
+	longest := 0
+	namespaces := []string{}
+	byNamespace := map[string][]*parser.Target{}
+	for _, target := range list.Sorted() {
+		if !listed(target) || (target.Desc == "" && !opts.ListAll) {
+			continue
+		}
+		if l := len(target.Name); l > longest {
+			longest = l
+		}
+		if _, ok := byNamespace[target.Namespace]; !ok && target.Namespace != "" {
+			namespaces = append(namespaces, target.Namespace)
+		}
+		byNamespace[target.Namespace] = append(byNamespace[target.Namespace], target)
+	}
+	sort.Strings(namespaces)
+
+	printed := false
+	for _, ns := range append([]string{""}, namespaces...) {
+		if len(byNamespace[ns]) == 0 {
+			continue
+		}
+		if ns != "" {
+			if printed {
+				fmt.Println()
+			}
+			fmt.Println("[" + ns + "]")
+		}
+		printCategories(byNamespace[ns], longest)
+		printed = true
+	}
+}
+
+// printCategories prints the targets of a namespace grouped by category,
+// with names padded to longest.
+func printCategories(list []*parser.Target, longest int) {
+	categories := []string{}
+	groups := map[string][]*parser.Target{}
+	for _, target := range list {
+		if _, ok := groups[target.Category]; !ok && target.Category != "" {
+			categories = append(categories, target.Category)
+		}
+		groups[target.Category] = append(groups[target.Category], target)
+	}
+	slongest := strconv.Itoa(longest)
+
+	indent := ""
+	for i, category := range append([]string{""}, categories...) {
+		targets := groups[category]
+		if len(targets) == 0 {
+			continue
+		}
+		if category != "" {
+			if i > 1 || len(groups[""]) > 0 {
+				fmt.Println()
+			}
+			fmt.Println(category + ":")
+			indent = "  "
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+		for _, target := range targets {
+			if target.Desc == "" {
+				fmt.Printf("%sdrmake %s\n", indent, target.Name)
+				continue
+			}
+			fmt.Printf("%sdrmake %-"+slongest+"s # %s\n", indent, target.Name, target.Desc)
+		}
+	}
+}
+
+// printStructured prints the targets in list as JSON or YAML.
+func printStructured(list parser.Targets, format string) error {
+	infos := []targetInfo{}
+	for _, t := range list {
+		if !listed(t) {
+			continue
+		}
+		var artifacts map[string]string
+		for _, a := range t.Artifacts {
+			if artifacts == nil {
+				artifacts = map[string]string{}
+			}
+			artifacts[a.Src] = a.Dst
+		}
+		infos = append(infos, targetInfo{
+			Name:         t.Name,
+			Description:  t.Desc,
+			Image:        t.Image,
+			Dependencies: t.Deps,
+			Artifacts:    artifacts,
+			Service:      t.Service,
+			Category:     t.Category,
+			Internal:     t.Internal,
+			Namespace:    t.Namespace,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	if format == "yaml" {
+		return yaml.NewEncoder(os.Stdout).Encode(infos)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(infos)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "log"
+
+type lockCommand struct {
+	Update bool `long:"update" description:"Resolve all images again instead of only the ones missing from the lock file"`
+}
+
+func init() {
+	argparser.AddCommand("lock", "Pin base images to their digests",
+		"Resolves the base image of every target to its registry digest and writes them to .drmake/lock. Images are then built from the pinned digests until the lock file is updated with --update.",
+		&lockCommand{})
+}
+
+func (c *lockCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	lock, err := rn.LoadLock()
+	if err != nil {
+		return err
+	}
+	if lock, err = rn.UpdateLock(list, lock, c.Update); err != nil {
+		return err
+	}
+	if err := rn.SaveLock(lock); err != nil {
+		return err
+	}
+	log.Printf("Locked %d image(s)\n", len(lock))
+	return nil
+}
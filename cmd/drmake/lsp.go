@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+type lspCommand struct{}
+
+func init() {
+	argparser.AddCommand("lsp", "Run a language server for build files",
+		"Runs a Language Server Protocol server on stdin and stdout for editors. It reports the problems drmake lint finds as diagnostics while build files are edited, goes to the definition of targets referenced with FROM #target, FROM &target, USING, COPYFROM and DEFAULT, and shows the description, base image and dependencies of a target on hover.",
+		&lspCommand{})
+}
+
+func (c *lspCommand) Execute(args []string) error {
+	s := &lspServer{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		root:     origdir,
+		docs:     map[string]string{},
+		lists:    map[string]parser.Targets{},
+		reported: map[string]bool{},
+	}
+	return s.serve()
+}
+
+// lspMessage is a JSON-RPC request, response or notification.
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *lspError        `json:"error"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// lspDocumentParams are the parameters of the textDocument requests and
+// notifications that are handled.
+type lspDocumentParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+}
+
+// lspServer is a language server for the build files of the project in
+// root. Open documents are parsed from the editor's contents rather than
+// from disk.
+type lspServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	root string
+
+	// docs are the contents of the open documents by path.
+	docs map[string]string
+
+	// entries are the build files that are parsed: the project's build
+	// file and the open documents that it does not include. lists are their
+	// targets as of their last successful parse.
+	entries []string
+	lists   map[string]parser.Targets
+
+	// reported are the URIs that diagnostics were last published for, so
+	// that they can be cleared once fixed.
+	reported map[string]bool
+}
+
+// serve handles messages until the client sends exit or closes stdin.
+func (s *lspServer) serve() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		var resp interface{} = lspResponse{JSONRPC: "2.0", ID: msg.ID, Result: result}
+		if rerr != nil {
+			resp = lspErrorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rerr}
+		}
+		if err := s.write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads a message with its Content-Length header.
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 && strings.EqualFold(kv[0], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(kv[1])); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", kv[1])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// write writes a message with its Content-Length header.
+func (s *lspServer) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// notify sends a notification to the client.
+func (s *lspServer) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(lspMessage{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+// handle handles a message and returns the result of requests.
+func (s *lspServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	var params lspDocumentParams
+	if len(msg.Params) > 0 {
+		json.Unmarshal(msg.Params, &params)
+	}
+	path := uriToPath(params.TextDocument.URI)
+
+	switch msg.Method {
+	case "initialize":
+		var init struct {
+			RootURI string `json:"rootUri"`
+		}
+		json.Unmarshal(msg.Params, &init)
+		if init.RootURI != "" {
+			s.root = uriToPath(init.RootURI)
+		}
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"definitionProvider": true,
+				"hoverProvider":      true,
+			},
+			"serverInfo": map[string]string{"name": "drmake", "version": version},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		s.docs[path] = params.TextDocument.Text
+		s.check()
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[path] = params.ContentChanges[n-1].Text
+		}
+		s.check()
+	case "textDocument/didSave":
+		s.check()
+	case "textDocument/didClose":
+		delete(s.docs, path)
+		s.check()
+	case "textDocument/definition":
+		if t := s.targetAt(path, params.Position); t != nil {
+			return lspLocation{URI: pathToURI(t.File), Range: s.lineRange(t.File, t.Line)}, nil
+		}
+		return nil, nil
+	case "textDocument/hover":
+		if t := s.targetAt(path, params.Position); t != nil {
+			return map[string]interface{}{
+				"contents": map[string]string{"kind": "markdown", "value": s.hover(t)},
+			}, nil
+		}
+		return nil, nil
+	default:
+		if msg.ID != nil {
+			return nil, &lspError{Code: -32601, Message: "method not found: " + msg.Method}
+		}
+	}
+	return nil, nil
+}
+
+// check parses the project's build file and the open documents it does not
+// include, and publishes their problems as diagnostics. Parse errors are
+// errors and the other problems found by lint are warnings.
+func (s *lspServer) check() {
+	main := makefile
+	if !filepath.IsAbs(main) {
+		main = filepath.Join(s.root, main)
+	}
+	others := []string{}
+	for path := range s.docs {
+		if path != main && !s.included(main, path) {
+			others = append(others, path)
+		}
+	}
+	sort.Strings(others)
+	entries := []string{}
+	_, open := s.docs[main]
+	if _, err := os.Stat(main); err == nil || open {
+		entries = append(entries, main)
+	}
+	entries = append(entries, others...)
+
+	popts := parser.Options{Args: opts.Args, Dir: s.root, Strict: opts.Strict, Files: s.docs}
+	diags := map[string][]lspDiagnostic{}
+	lists := map[string]parser.Targets{}
+	for _, entry := range entries {
+		list, _, err := parser.ParseFile(entry, popts)
+		errs := map[string]bool{}
+		if perrs, ok := err.(parser.ErrorList); ok {
+			for _, perr := range perrs {
+				errs[perr.Error()] = true
+			}
+		}
+		if err == nil {
+			lists[entry] = list
+		} else if prev := s.lists[entry]; prev != nil {
+			lists[entry] = prev
+		}
+
+		lerrs, ok := parser.Lint(entry, popts).(parser.ErrorList)
+		if !ok {
+			continue
+		}
+		for _, lerr := range lerrs {
+			severity := 2
+			if errs[lerr.Error()] {
+				severity = 1
+			}
+			file := lerr.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(s.root, file)
+			}
+			uri := pathToURI(file)
+			diags[uri] = append(diags[uri], lspDiagnostic{
+				Range:    s.lineRange(file, lerr.Line),
+				Severity: severity,
+				Source:   "drmake",
+				Message:  lerr.Msg,
+			})
+		}
+	}
+	s.entries, s.lists = entries, lists
+
+	for uri := range s.reported {
+		if diags[uri] == nil {
+			diags[uri] = []lspDiagnostic{}
+		}
+	}
+	s.reported = map[string]bool{}
+	for uri, list := range diags {
+		if len(list) > 0 {
+			s.reported[uri] = true
+		}
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": list})
+	}
+}
+
+// included returns whether path declares targets of the project's build
+// file main, as of its last successful parse.
+func (s *lspServer) included(main, path string) bool {
+	for _, t := range s.lists[main] {
+		if t.File == path {
+			return true
+		}
+	}
+	return false
+}
+
+// target returns the target named name from the parsed build files,
+// preferring the project's build file, and the targets it is declared with.
+func (s *lspServer) target(name string) (*parser.Target, parser.Targets) {
+	for _, entry := range s.entries {
+		if t := s.lists[entry][name]; t != nil {
+			return t, s.lists[entry]
+		}
+	}
+	return nil, nil
+}
+
+// lines returns the lines of the file at path, from the editor if it is
+// open.
+func (s *lspServer) lines(path string) []string {
+	text, ok := s.docs[path]
+	if !ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		text = string(data)
+	}
+	return strings.Split(text, "\n")
+}
+
+// lineRange returns the range of the 1-based line num of the file at path.
+func (s *lspServer) lineRange(path string, num int) lspRange {
+	r := lspRange{Start: lspPosition{Line: num - 1}, End: lspPosition{Line: num - 1}}
+	if lines := s.lines(path); num > 0 && num <= len(lines) {
+		r.End.Character = len(strings.TrimRight(lines[num-1], "\r"))
+	}
+	return r
+}
+
+// targetAt returns the target referenced or declared by the word at pos of
+// a FROM, SERVICE, TARGET, USING, COPYFROM or DEFAULT line.
+func (s *lspServer) targetAt(path string, pos lspPosition) *parser.Target {
+	lines := s.lines(path)
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return nil
+	}
+	line := lines[pos.Line]
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "FROM", "SERVICE", "TARGET", "USING", "COPYFROM", "DEFAULT":
+	default:
+		return nil
+	}
+
+	isWord := func(c byte) bool {
+		return c != ' ' && c != '\t' && c != '\r' && c != '"' && c != ','
+	}
+	start, end := pos.Character, pos.Character
+	if start > len(line) {
+		return nil
+	}
+	for start > 0 && isWord(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWord(line[end]) {
+		end++
+	}
+	word := strings.TrimLeft(line[start:end], "#&")
+	if word == "" {
+		return nil
+	}
+	t, _ := s.target(word)
+	return t
+}
+
+// hover returns the Markdown shown when hovering a target: its description,
+// the base image its Dockerfile is resolved to and its dependencies.
+func (s *lspServer) hover(t *parser.Target) string {
+	_, list := s.target(t.Name)
+	lines := []string{"**" + t.Name + "**"}
+	if t.Desc != "" {
+		lines[0] += " — " + t.Desc
+	}
+	switch {
+	case t.Phony:
+		lines = append(lines, "Target without an image")
+	default:
+		image := t.Image
+		if dfile, err := t.Dockerfile(list, s.root); err == nil {
+			for _, line := range strings.Split(dfile, "\n") {
+				if f := strings.Fields(line); len(f) > 1 && strings.EqualFold(f[0], "FROM") {
+					image = f[1]
+					break
+				}
+			}
+		}
+		kind := "Base image"
+		if t.Service {
+			kind = "Service image"
+		}
+		lines = append(lines, fmt.Sprintf("%s: `%s`", kind, image))
+	}
+	if len(t.Deps) > 0 {
+		lines = append(lines, "Dependencies: "+strings.Join(t.Deps, ", "))
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// uriToPath returns the path of a file:// URI.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = filepath.FromSlash(strings.TrimPrefix(path, "/"))
+	}
+	return filepath.Clean(path)
+}
+
+// pathToURI returns the file:// URI of an absolute path.
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
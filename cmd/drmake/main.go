@@ -1,20 +1,17 @@
 package main
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
-	"regexp"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/lsegal/drmake/pkg/parser"
+	"github.com/lsegal/drmake/pkg/runner"
 )
 
 const (
@@ -25,373 +22,369 @@ const (
 
 var (
 	opts struct {
-		Makefile  string   `short:"f" long:"file" value-name:"FILE" default:"Makefile.phd" description:"The build file to parse targets from"`
-		Fresh     bool     `long:"fresh" description:"Run containers in fresh volume (defaults to false)"`
-		Host      bool     `long:"host" description:"Mount images to host workspace volume"`
-		PrintList bool     `short:"l" long:"list" description:"Print a list of targets"`
-		Args      []string `short:"a" long:"arg" value-name:"ARG=value" description:"An argument in the form ARG=value to pass to a target"`
-		Version   bool     `long:"version" description:"Show version information"`
+		Makefile          string        `short:"f" long:"file" value-name:"FILE" default:"Makefile.phd" description:"The build file to parse targets from, which may be an http(s) URL, a git source as in git::github.com/org/repo//file.phd?ref=v1 or - to read it from stdin"`
+		FileSHA256        string        `long:"file-sha256" value-name:"HASH" description:"Fail unless the build file has the SHA-256 checksum HASH, and use a cached remote build file with that checksum without fetching it again"`
+		Runtime           string        `long:"runtime" env:"DRMAKE_RUNTIME" value-name:"NAME" default:"docker" choice:"docker" choice:"podman" choice:"nerdctl" description:"The container runtime used to build and run targets"`
+		Context           string        `long:"context" value-name:"NAME" description:"The docker context (or podman connection) used to build and run targets"`
+		DockerHost        string        `long:"docker-host" value-name:"HOST" description:"The address of the daemon used to build and run targets, such as ssh://user@host"`
+		Remote            string        `long:"remote" value-name:"[USER@]HOST" description:"Build and run targets with the docker daemon of HOST over SSH"`
+		CacheFrom         []string      `long:"cache-from" value-name:"REPO" description:"Import the build cache of every target from REPO:target (or a full BuildKit cache spec)"`
+		CacheTo           []string      `long:"cache-to" value-name:"REPO" description:"Export the build cache of every target to REPO:target (or a full BuildKit cache spec)"`
+		ImageCache        string        `long:"image-cache" env:"DRMAKE_IMAGE_CACHE" value-name:"REPO" description:"Pull target images with an unchanged Dockerfile and build args from REPO instead of building them, and push newly built ones"`
+		CPUs              string        `long:"cpus" value-name:"N" description:"Limit target containers to N CPUs (overridden by CPUS directives)"`
+		Memory            string        `long:"memory" value-name:"SIZE" description:"Limit the memory of target containers, such as 2g (overridden by MEMORY directives)"`
+		ShmSize           string        `long:"shm-size" value-name:"SIZE" description:"Set the size of /dev/shm in target containers (overridden by SHM_SIZE directives)"`
+		Pull              bool          `long:"pull" description:"Always pull newer versions of base images when building (overridden by PULL directives)"`
+		NoCache           bool          `long:"no-cache" description:"Build images without the layer cache"`
+		Deterministic     bool          `long:"deterministic" description:"Give artifacts the modification time SOURCE_DATE_EPOCH (or 1970-01-01) so archives of them are reproducible"`
+		Manifest          string        `long:"manifest" value-name:"FILE" description:"Write the path, size and SHA-256 of every artifact to the JSON file FILE"`
+		Checksums         bool          `long:"checksums" description:"Write a .sha256 file next to every artifact"`
+		Report            string        `long:"report" value-name:"FILE" description:"Write the result of every target to FILE, as a JUnit report if it ends with .xml and as JSON otherwise"`
+		StatsD            string        `long:"statsd" env:"DRMAKE_STATSD" value-name:"HOST:PORT" description:"Send the duration, cache hits and success of every target to a StatsD server"`
+		Pushgateway       string        `long:"pushgateway" env:"DRMAKE_PUSHGATEWAY" value-name:"URL" description:"Push the duration, cache hits and success of every target to a Prometheus Pushgateway"`
+		User              bool          `long:"user" description:"Run target containers as the invoking user in host mode, so files they write are not owned by root"`
+		Isolate           bool          `long:"isolate" description:"Run every target in its own copy of the workspace volume, keeping only its artifacts"`
+		Wait              bool          `long:"wait" description:"Wait for another drmake run in the same project to finish instead of failing"`
+		Force             bool          `long:"force" description:"Run even if another drmake run in the same project is in progress"`
+		AutoPrune         bool          `long:"auto-prune" env:"DRMAKE_AUTO_PRUNE" description:"Prune the project's unused volumes and images after running targets, as drmake prune does"`
+		PreRun            []string      `long:"pre-run" value-name:"COMMAND" description:"Run the shell command COMMAND on the host before running targets"`
+		PostRun           []string      `long:"post-run" value-name:"COMMAND" description:"Run the shell command COMMAND on the host after running targets"`
+		Notify            bool          `long:"notify" description:"Show a desktop notification when the run finishes, and post a summary to --notify-webhook if set"`
+		NotifyWebhook     string        `long:"notify-webhook" env:"DRMAKE_NOTIFY_WEBHOOK" value-name:"URL" description:"With --notify, post a JSON summary of the run to URL (a Slack incoming webhook works)"`
+		Offline           bool          `long:"offline" description:"Build images and run containers without network access"`
+		AllowPrivileged   bool          `long:"allow-privileged" description:"Allow targets to use PRIVILEGED, CAP_ADD, DEVICE and DOCKER_SOCKET"`
+		Verbose           bool          `short:"v" long:"verbose" description:"Log runtime commands, generated Dockerfiles and the files synced into the workspace volume"`
+		Quiet             bool          `short:"q" long:"quiet" description:"Only show the output of image builds that fail"`
+		NoColor           bool          `long:"no-color" description:"Print target names before their output without colors (the default when NO_COLOR is set or not attached to a terminal)"`
+		Output            string        `long:"output" value-name:"FORMAT" default:"text" choice:"text" choice:"json" description:"With json, write a line of JSON to stdout for every target started, image built, container finished and artifact copied"`
+		OutputFile        string        `long:"output-file" value-name:"FILE" description:"With --output json, append the events to FILE instead of stdout"`
+		Fresh             bool          `long:"fresh" description:"Run containers in fresh volume (defaults to false)"`
+		Host              bool          `long:"host" description:"Mount images to host workspace volume"`
+		RemoveInterrupted bool          `long:"rm-interrupted" description:"Remove the image of a target that is interrupted by SIGINT or SIGTERM"`
+		BuildKit          bool          `long:"buildkit" env:"DRMAKE_BUILDKIT" description:"Build images with BuildKit (enabled automatically for targets using SECRET or SSH)"`
+		Push              bool          `long:"push" description:"Push images named by TAG directives after their target runs successfully"`
+		Platform          string        `long:"platform" value-name:"PLATFORMS" description:"Comma separated platforms to build and run targets for, overriding PLATFORM directives"`
+		Interactive       bool          `long:"interactive" description:"Always run containers with an interactive TTY"`
+		NoInteractive     bool          `long:"no-interactive" description:"Never run containers with an interactive TTY (the default when not attached to a terminal)"`
+		Timeout           time.Duration `long:"timeout" value-name:"DURATION" description:"Stop and fail targets whose container runs longer than DURATION (overridden by TIMEOUT directives)"`
+		Retries           int           `long:"retries" value-name:"N" description:"Retry failed target containers up to N times (overridden by RETRY directives)"`
+		KeepGoing         bool          `short:"k" long:"keep-going" description:"Keep running targets that do not depend on a failed target, and report all failures at the end"`
+		VolumePrefix      string        `long:"volume-prefix" env:"DRMAKE_VOLUME_PREFIX" value-name:"PREFIX" description:"Name the workspace and cache volumes PREFIX-ws and PREFIX-cache instead of deriving them from the project path"`
+		HelperImage       string        `long:"helper-image" env:"DRMAKE_HELPER_IMAGE" value-name:"IMAGE" default:"alpine" description:"The image used for helper containers of the workspace volume, which needs sh, cp, rm, tar and xargs to delete files removed from the project and to ISOLATE targets"`
+		Resume            bool          `long:"resume" description:"Skip targets that succeeded with unchanged inputs in the previous failed run, starting from the first failure"`
+		HealthTimeout     time.Duration `long:"health-timeout" value-name:"DURATION" default:"1m" description:"How long to wait for service targets to pass their HEALTHCHECK (overridden by TIMEOUT directives on services, 0 waits forever)"`
+		Env               []string      `short:"e" long:"env" value-name:"VAR[=value]" description:"Set an environment variable in target containers, forwarding the host value if no value is given"`
+		EnvFile           []string      `long:"env-file" value-name:"FILE" description:"Set environment variables in target containers from a dotenv file"`
+		Incremental       bool          `short:"i" long:"incremental" description:"Skip targets whose Dockerfile, args and SOURCES are unchanged since their last successful run"`
+		AlwaysMake        bool          `short:"B" long:"always-make" description:"Run targets even if their SOURCES are unchanged since their last successful run"`
+		Affected          bool          `long:"affected" description:"Only run the targets (of those given, or of all targets) affected by the files changed since --since, and the targets that depend on them"`
+		Since             string        `long:"since" value-name:"REV" default:"origin/main" description:"With --affected, the git revision to compare the project against"`
+		Watch             bool          `short:"w" long:"watch" description:"Re-run targets whenever files in the project change"`
+		WatchInterval     time.Duration `long:"watch-interval" value-name:"DURATION" default:"1s" description:"How often to poll for changes in watch mode"`
+		PrintList         bool          `short:"l" long:"list" description:"Print a list of targets"`
+		PrintDockerfile   bool          `long:"print-dockerfile" description:"Print the generated Dockerfile of the targets instead of running them"`
+		ListAll           bool          `long:"all" description:"With --list, also list internal targets and targets without a description"`
+		Category          string        `long:"category" value-name:"CATEGORY" description:"With --list, only list targets in CATEGORY"`
+		Namespace         string        `long:"namespace" value-name:"NAMESPACE" description:"With --list, only list targets in NAMESPACE"`
+		Format            string        `long:"format" value-name:"FORMAT" default:"text" choice:"text" choice:"json" choice:"yaml" description:"The output format of --list"`
+		Strict            bool          `long:"strict" description:"Treat unknown instructions, duplicate targets, references to targets before they are declared and malformed target lines in the build file as errors"`
+		Args              []string      `short:"a" long:"arg" value-name:"ARG=value" description:"An argument in the form ARG=value to pass to a target"`
+		Version           bool          `long:"version" description:"Show version information"`
 	}
 
+	argparser = flags.NewParser(&opts, flags.Default)
+
 	tempdir string
 	origdir string
+	rn      *runner.Runner
 
-	reFromLine = regexp.MustCompile(`(?i)^FROM\s+(\S+)(?:\s+AS\s+(\S+))?(?:\s+USING\s+(.+)$)?`)
+	// containerArgs are the arguments given after --, which replace the
+	// command of the last target's container.
+	containerArgs []string
 )
 
-type target struct {
-	name  string
-	image string
-	defn  string
-	desc  string
-	deps  []string
-
-	artifacts map[string]string
+func main() {
+	os.Exit(drmake())
 }
 
-type targetlist map[string]*target
-
-func (s targetlist) find(name string) *target {
-	if s[name] == nil {
-		log.Fatal("Unknown target: ", name)
+// drmake runs the tool and returns its exit code. It is kept separate from
+// main so that deferred cleanup runs before the process exits.
+func drmake() int {
+	// Subcommands are executed after the runtime and working directories are
+	// set up below, so only record which one was selected while parsing.
+	var command flags.Commander
+	argparser.SubcommandsOptional = true
+	argparser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		command = cmd
 		return nil
 	}
-	return s[name]
-}
-
-func (s *target) String() string {
-	return fmt.Sprintf("target %s FROM %s: %s\n%s",
-		s.name, s.image, strings.Join(s.deps, " "), s.defn)
-}
-
-func (s *target) Run(list targetlist) {
-	dfile := s.Dockerfile(list)
-	if dfile != "" || !strings.HasPrefix(s.image, "#") {
-		args := []string{"build", "--rm", "-t", image() + "/" + s.name}
-		buildArgs := []string{}
-		for _, arg := range opts.Args {
-			buildArgs = append(buildArgs, []string{"--build-arg", arg}...)
-		}
-		args = append(args, buildArgs...)
-		args = append(args, "-")
-		cmd := exec.Command("docker", args...)
-		cmd.Stdin = strings.NewReader(dfile)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			os.Exit(1)
-		}
-
-		cmd = exec.Command("docker", "run", "--rm", "-v", cachevol()+":/root",
-			"-v", wsvol()+":/work", "-w", "/work", "-it", image()+"/"+s.name)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			os.Exit(1)
-		}
-	}
-
-	if !opts.Host && len(s.artifacts) > 0 {
-		uid := os.Getuid()
-		gid := os.Getgid()
-		for src, dst := range s.artifacts {
-			finaldst := filepath.Join(origdir, filepath.FromSlash(dst))
-			log.Printf("Copying artifact %s to %s\n", src, finaldst)
-			copyVolAll("/work/"+src, "/srv/"+dst)
-			filepath.Walk(finaldst, func(name string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				return os.Chown(name, uid, gid)
-			})
-		}
-	}
-}
 
-func (s *target) Dockerfile(list targetlist) string {
-	preface := "FROM " + s.image
-	if strings.HasPrefix(s.image, "&") {
-		preface = s.dockerfileFromPath(filepath.Join(".drmake", "targets", s.image[1:]), list)
-	} else if strings.HasPrefix(s.image, "#") {
-		if s.image[1:] == s.name {
-			return ""
+	// Everything after -- is passed to the container of the last target,
+	// so it must not be parsed as flags or target names.
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == "--" {
+			args, containerArgs = args[:i], args[i+1:]
+			break
 		}
-		pretarget := list.find(s.image[1:])
-		preface = strings.Trim(pretarget.Dockerfile(list), " \r\n")
-	} else if strings.HasPrefix(s.image, "./") {
-		preface = s.dockerfileFromPath(s.image[2:], list)
 	}
-	os.Chdir(tempdir)
-	return strings.Join([]string{preface, s.defn}, "\n")
-}
-
-func (s *target) dockerfileFromPath(path string, list targetlist) string {
-	os.Chdir(filepath.Join(origdir, path))
-	data, err := ioutil.ReadFile("Dockerfile")
+	cfg, err := configArgs()
 	if err != nil {
-		log.Fatalf("Failed to read image: %s: %v", s.image, err)
-		return ""
+		log.Print(err)
+		return 1
 	}
-	return strings.Trim(string(data), " \r\n")
-}
-
-func main() {
-	runTargetNames, err := flags.Parse(&opts)
+	runTargetNames, err := argparser.ParseArgs(append(cfg, args...))
 	if err != nil {
-		os.Exit(1)
+		return 1
 	}
 
 	if opts.Version {
 		fmt.Println("drmake " + version)
-		return
+		return 0
 	}
 
-	origdir, _ = os.Getwd()
-	tempdir, _ = ioutil.TempDir("", "")
-	defer os.RemoveAll(tempdir)
-
-	list := targetlist{}
-	defaultTarget := parseMakefile(list)
-	if len(runTargetNames) == 0 {
-		runTargetNames = []string{defaultTarget}
-	}
-
-	if opts.PrintList {
-		print(list)
-		return
+	if opts.Verbose && opts.Quiet {
+		log.Print("--verbose cannot be combined with --quiet")
+		return 1
 	}
 
-	run(list, runTargetNames)
-}
-
-func print(list targetlist) {
-	longest := 0
-	namelist := []string{}
-	for name, target := range list {
-		if l := len(name); l > longest {
-			if target.desc == "" {
-				continue
-			}
-			longest = l
-			namelist = append(namelist, name)
-		}
+	endpoint, err := runtimeEndpoint()
+	if err != nil {
+		log.Print(err)
+		return 1
 	}
-	slongest := strconv.Itoa(longest)
-
-	sort.Strings(namelist)
-	for _, name := range namelist {
-		target := list[name]
-		fmt.Printf("drmake %-"+slongest+"s # %s\n", name, target.desc)
+	rt, err := runner.NewRuntime(opts.Runtime, endpoint)
+	if err != nil {
+		log.Print(err)
+		return 1
 	}
-}
 
-func run(list targetlist, runTargetNames []string) {
-	if len(runTargetNames) == 0 {
-		runTargetNames = []string{defaultTarget}
-	}
-	runTargets := buildExecOrder(list, runTargetNames)
-	orderedTargets := make([]string, len(runTargets))
-	for i, s := range runTargets {
-		orderedTargets[i] = s.name
+	origdir, _ = os.Getwd()
+	tempdir, _ = ioutil.TempDir("", "")
+	defer os.RemoveAll(tempdir)
+	if _, lsp := command.(*lspCommand); lsp && opts.Makefile == "-" {
+		log.Print("The language server reads requests from stdin, so it cannot read the build file from it")
+		return 1
 	}
-	prepVolume()
-	for _, target := range runTargets {
-		target.Run(list)
+	if makefile, err = localBuildFile(opts.Makefile); err != nil {
+		log.Print(err)
+		return 1
 	}
-}
 
-func parseMakefile(list targetlist) (defaultTarget string) {
-	var atarget *target
-	data, err := ioutil.ReadFile(opts.Makefile)
+	ropts, err := runnerOptions()
 	if err != nil {
-		log.Fatalf("Failed to find %s: %v", opts.Makefile, err)
-		return
+		log.Print(err)
+		return 1
 	}
-
-	lines := strings.Split(string(data), "\n")
-	prev := ""
-	for _, line := range lines {
-		line = prev + strings.Trim(line, " \r\n")
-		if strings.HasSuffix(line, " \\") {
-			prev = line[0 : len(line)-1]
-			continue
-		} else {
-			prev = ""
-		}
-		if line == "" || line[0] == '#' {
-			continue
-		}
-
-		c := strings.Fields(line)
-		if len(c) > 0 && strings.ToUpper(c[0]) == "FROM" {
-			match := reFromLine.FindStringSubmatch(line)
-			if len(match) < 2 {
-				continue
-			}
-
-			image := match[1]
-			name := match[2]
-			deps := strings.Fields(match[3])
-			if name == "" {
-				c := regexp.MustCompile(`\b`).Split(image, -1)
-				name = c[len(c)-1]
+	if opts.Output == "json" {
+		ropts.Events = os.Stdout
+		if opts.OutputFile != "" {
+			f, err := os.OpenFile(opts.OutputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				log.Print(err)
+				return 1
 			}
-
-			atarget = &target{
-				name:      name,
-				image:     image,
-				deps:      deps,
-				artifacts: map[string]string{},
-			}
-			list[atarget.name] = atarget
-			if defaultTarget == "" {
-				defaultTarget = atarget.name
+			defer f.Close()
+			ropts.Events = f
+		}
+	}
+	rn = runner.New(rt, ropts)
+	handleSignals()
+
+	if command != nil {
+		if err := command.Execute(runTargetNames); err != nil {
+			logError(err)
+			if interrupted() {
+				return exitInterrupted
 			}
-			continue
+			return runner.ExitCode(err)
 		}
+		return 0
+	}
 
-		if atarget == nil {
-			continue
+	list, first, err := parseMakefile()
+	if err != nil {
+		logError(err)
+		return 1
+	}
+	if opts.Affected {
+		names, err := affectedTargets(list, runTargetNames)
+		if err != nil {
+			log.Print(err)
+			return 1
 		}
-
-		if len(c) > 1 && strings.ToUpper(c[0]) == "ARTIFACT" {
-			var src string
-			var dst string
-			artargs := strings.Join(c[1:], " ")
-			splitchr := " "
-			if strings.Contains(artargs, "=") {
-				splitchr = "="
-			}
-
-			s := strings.SplitN(artargs, splitchr, 2)
-			src = s[0]
-			if len(s) == 2 {
-				dst = s[1]
-			} else {
-				dst = s[0]
-			}
-			atarget.artifacts[src] = dst
-			continue
+		if len(names) == 0 {
+			log.Printf("No targets are affected by changes since %s\n", opts.Since)
+			return 0
 		}
-
-		if len(c) > 1 && strings.ToUpper(c[0]) == "ENVARG" {
-			atarget.defn += line[3:] + "\n"
-			if len(c) != 2 {
-				log.Fatal("ENVARG requires exactly one argument")
-			}
-			parts := strings.SplitN(c[1], "=", 2)
-			atarget.defn += fmt.Sprintf("ENV %s=${%s}\n", parts[0], parts[0])
-			continue
+		runTargetNames = names
+	}
+	if len(runTargetNames) == 0 {
+		if first == "" {
+			first = defaultTarget
 		}
+		runTargetNames = []string{first}
+	}
 
-		if len(c) > 1 && strings.ToUpper(c[0]) == "LABEL" {
-			kv := strings.SplitN(strings.Join(c[1:], " "), "=", 2)
-			if len(kv) == 2 && strings.ToLower(strings.Trim(kv[0], `"`)) == "description" {
-				atarget.desc = strings.Trim(kv[1], `"`)
+	if opts.PrintList {
+		if opts.Format != "text" {
+			if err := printStructured(list, opts.Format); err != nil {
+				log.Print(err)
+				return 1
 			}
+			return 0
 		}
-
-		atarget.defn += line + "\n"
+		printList(list)
+		return 0
 	}
-	return
-}
-
-func buildExecOrder(list targetlist, targets []string) (out []*target) {
-	unordTargets := []string{}
-	ordTargets := map[string]int{}
 
-	for _, targName := range targets {
-		target := list.find(targName)
-		depTargets := buildExecOrder(list, target.deps)
-		depTargetNames := make([]string, len(depTargets))
-		for i, s := range depTargets {
-			depTargetNames[i] = s.name
+	if opts.PrintDockerfile {
+		if err := printDockerfiles(list, runTargetNames); err != nil {
+			log.Print(err)
+			return 1
 		}
-		unordTargets = append(unordTargets, append(append([]string{}, depTargetNames...), targName)...)
+		return 0
 	}
 
-	n := 0
-	for _, name := range unordTargets {
-		if ordTargets[name] != 0 {
-			continue
+	if prompted, err := checkRequired(list, runTargetNames); err != nil {
+		log.Print(err)
+		return 1
+	} else if prompted {
+		rn.Args = opts.Args
+		if list, _, err = parseMakefile(); err != nil {
+			logError(err)
+			return 1
 		}
-
-		n++
-		ordTargets[name] = n
 	}
 
-	out = make([]*target, len(ordTargets))
-	for name, idx := range ordTargets {
-		out[idx-1] = list.find(name)
+	runfn := rn.Run
+	if opts.Watch {
+		runfn = watch
 	}
-
-	return
+	defer autoPrune()
+	start := time.Now()
+	err = runfn(list, runTargetNames)
+	notifyRun(runTargetNames, start, err)
+	if err != nil {
+		log.Print(err)
+		if interrupted() {
+			return exitInterrupted
+		}
+		return runner.ExitCode(err)
+	}
+	return 0
 }
 
-func prepVolume() {
-	if opts.Host {
-		return
+// runtimeEndpoint returns the daemon selected by --context, --docker-host
+// and --remote. --remote is a shorthand for an ssh:// docker host.
+func runtimeEndpoint() (runner.Endpoint, error) {
+	endpoint := runner.Endpoint{Context: opts.Context, Host: opts.DockerHost}
+	if opts.Remote == "" {
+		return endpoint, nil
 	}
-
-	vols := []string{wsvol(), cachevol()}
-
-	for _, vol := range vols {
-		if opts.Fresh {
-			cmd := exec.Command("docker", "volume", "rm", "-f", vol)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Run()
-		}
+	if opts.Runtime != "docker" {
+		return endpoint, fmt.Errorf("--remote requires the docker runtime, use --context with a %s connection instead", opts.Runtime)
 	}
-
-	cmd := exec.Command("docker", "volume", "create", wsvol())
-	if err := cmd.Run(); err == nil {
-		copyVol("/srv/.", "/work")
+	if endpoint.Host != "" || endpoint.Context != "" {
+		return endpoint, fmt.Errorf("--remote cannot be combined with --docker-host or --context")
 	}
+	endpoint.Host = "ssh://" + strings.TrimPrefix(opts.Remote, "ssh://")
+	return endpoint, nil
 }
 
-func copyVolAll(src, dst string) error {
-	if opts.Host {
-		return nil
+// artifactTime returns the modification time that --deterministic gives
+// artifacts: SOURCE_DATE_EPOCH if set, or the Unix epoch.
+func artifactTime() (time.Time, error) {
+	if !opts.Deterministic {
+		return time.Time{}, nil
 	}
-	finaldst := dst
-	if !strings.HasSuffix(finaldst, "/") {
-		finaldst = path.Dir(finaldst)
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Unix(0, 0), nil
 	}
-	var dir string
-	if finaldst == "/srv" {
-		dir = origdir
-	} else if strings.HasPrefix(finaldst, "/srv/") {
-		dir = filepath.FromSlash(strings.Replace(finaldst, "/srv/", origdir+"/", 1))
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %s", epoch)
 	}
-	os.MkdirAll(dir, 0775)
-	return copyVol(src, dst)
+	return time.Unix(secs, 0), nil
 }
 
-func copyVol(src, dst string) error {
-	if opts.Host {
-		return nil
-	}
-	log.Printf("Copying data: %s -> %s\n", src, dst)
-	cmd := exec.Command("docker", "run", "--rm", "-v", origdir+":/srv", "-v",
-		wsvol()+":/work", "alpine", "sh", "-c", "cp -R "+src+" "+dst)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// parseMakefile parses the build file and returns its targets and the name
+// of its first target.
+func parseMakefile() (parser.Targets, string, error) {
+	start := time.Now()
+	list, first, err := parser.ParseFile(makefile, parser.Options{Args: opts.Args, Dir: origdir, Strict: opts.Strict, Files: buildFiles})
+	rn.RecordParse(start, err)
+	return list, first, err
 }
 
-func wsvol() string {
-	if opts.Host {
-		return origdir
+// logError logs err. The problems of a parse error are printed one per
+// line, as file:line: message.
+func logError(err error) {
+	if errs, ok := err.(parser.ErrorList); ok {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return
 	}
-	return fmt.Sprintf("drmake-ws-%x", sha1.Sum([]byte(opts.Makefile)))
-}
-
-func cachevol() string {
-	return fmt.Sprintf("drmake-cache-%x", sha1.Sum([]byte(opts.Makefile)))
+	log.Print(err)
 }
 
-func image() string {
-	return fmt.Sprintf("drmake-%x", sha1.Sum([]byte(opts.Makefile)))
+// runnerOptions returns the runner options set by the command line.
+func runnerOptions() (runner.Options, error) {
+	mtime, err := artifactTime()
+	if err != nil {
+		return runner.Options{}, err
+	}
+	return runner.Options{
+		Dir:               origdir,
+		Makefile:          opts.Makefile,
+		TempDir:           tempdir,
+		Args:              opts.Args,
+		CommandArgs:       containerArgs,
+		Fresh:             opts.Fresh,
+		Host:              opts.Host,
+		RemoveInterrupted: opts.RemoveInterrupted,
+		BuildKit:          opts.BuildKit,
+		Push:              opts.Push,
+		Platform:          opts.Platform,
+		TTY:               interactive(),
+		Timeout:           opts.Timeout,
+		Retries:           opts.Retries,
+		KeepGoing:         opts.KeepGoing,
+		VolumePrefix:      opts.VolumePrefix,
+		HelperImage:       opts.HelperImage,
+		Resume:            opts.Resume,
+		HealthTimeout:     opts.HealthTimeout,
+		Env:               opts.Env,
+		EnvFile:           opts.EnvFile,
+		Incremental:       opts.Incremental,
+		AlwaysMake:        opts.AlwaysMake,
+		CacheFrom:         opts.CacheFrom,
+		CacheTo:           opts.CacheTo,
+		ImageCache:        opts.ImageCache,
+		CPUs:              opts.CPUs,
+		Memory:            opts.Memory,
+		ShmSize:           opts.ShmSize,
+		Pull:              opts.Pull,
+		NoCache:           opts.NoCache,
+		AllowPrivileged:   opts.AllowPrivileged,
+		Offline:           opts.Offline,
+		User:              opts.User,
+		Verbose:           opts.Verbose,
+		Quiet:             opts.Quiet,
+		NoColor:           opts.NoColor || os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stderr),
+		Isolate:           opts.Isolate,
+		Wait:              opts.Wait,
+		Force:             opts.Force,
+		ArtifactTime:      mtime,
+		Manifest:          opts.Manifest,
+		Checksums:         opts.Checksums,
+		Report:            opts.Report,
+		PreRun:            opts.PreRun,
+		PostRun:           opts.PostRun,
+		StatsD:            opts.StatsD,
+		Pushgateway:       opts.Pushgateway,
+		OTelEndpoint:      os.Getenv("DRMAKE_OTEL_ENDPOINT"),
+	}, nil
 }
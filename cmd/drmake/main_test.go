@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArtifactTime(t *testing.T) {
+	tests := []struct {
+		name          string
+		deterministic bool
+		epoch         string
+		want          time.Time
+		err           bool
+	}{
+		{"not deterministic", false, "100", time.Time{}, false},
+		{"unix epoch", true, "", time.Unix(0, 0), false},
+		{"source date epoch", true, "100", time.Unix(100, 0), false},
+		{"invalid", true, "yesterday", time.Time{}, true},
+	}
+	defer func(deterministic bool) { opts.Deterministic = deterministic }(opts.Deterministic)
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts.Deterministic = tt.deterministic
+			os.Setenv("SOURCE_DATE_EPOCH", tt.epoch)
+			got, err := artifactTime()
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// notifyRun reports the outcome of a run of the targets names with a
+// desktop notification and, if --notify-webhook is set, a JSON summary
+// posted to the webhook. It does nothing without --notify.
+func notifyRun(names []string, start time.Time, err error) {
+	if !opts.Notify {
+		return
+	}
+	duration := time.Since(start).Round(time.Second)
+	status, message := "ok", fmt.Sprintf("%s succeeded in %s", strings.Join(names, " "), duration)
+	if err != nil {
+		status, message = "failed", fmt.Sprintf("%s failed after %s: %v", strings.Join(names, " "), duration, err)
+	}
+
+	if err := desktopNotification("drmake", message); err != nil {
+		log.Printf("Failed to show notification: %v\n", err)
+	}
+	if opts.NotifyWebhook != "" {
+		summary := map[string]interface{}{
+			"text":     "drmake: " + message,
+			"status":   status,
+			"targets":  names,
+			"duration": time.Since(start).Seconds(),
+			"project":  origdir,
+		}
+		if err := postWebhook(opts.NotifyWebhook, summary); err != nil {
+			log.Printf("Failed to notify webhook: %v\n", err)
+		}
+	}
+}
+
+// desktopNotification shows a notification with notify-send on Linux and
+// osascript on macOS. Elsewhere, or when the tool is not installed, it does
+// nothing.
+func desktopNotification(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, title))
+	default:
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return nil
+		}
+		cmd = exec.Command("notify-send", title, message)
+	}
+	return cmd.Run()
+}
+
+// postWebhook posts body as JSON to url. The text field makes it a valid
+// Slack message.
+func postWebhook(url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type pruneCommand struct {
+	AllProjects  bool   `long:"all-projects" description:"Prune the volumes and images of every drmake project on this machine"`
+	UnusedFor    string `long:"unused-for" env:"DRMAKE_PRUNE_UNUSED_FOR" value-name:"AGE" default:"30d" description:"Remove volumes and images that were not used for AGE, such as 30d or 12h (0 disables)"`
+	MaxCacheSize string `long:"max-cache-size" env:"DRMAKE_PRUNE_MAX_CACHE_SIZE" value-name:"SIZE" description:"Remove the least recently used cache volumes until the rest use at most SIZE, such as 10GB"`
+	DryRun       bool   `short:"n" long:"dry-run" description:"Only print what would be removed"`
+}
+
+// pruneOpts are the options of the prune subcommand, which --auto-prune
+// uses as well.
+var pruneOpts = &pruneCommand{}
+
+func init() {
+	argparser.AddCommand("prune", "Remove the project's unused volumes and images",
+		"Removes the volumes and target images of the project that were not used by a run for --unused-for, "+
+			"and the least recently used cache volumes while all of them use more than --max-cache-size. "+
+			"Volumes and images that were never used by a run are judged by when they were created, if the runtime reports it. "+
+			"Resources in use by a container are kept.",
+		pruneOpts)
+}
+
+func (c *pruneCommand) Execute(args []string) error {
+	age, err := parseAge(c.UnusedFor)
+	if err != nil {
+		return fmt.Errorf("invalid --unused-for %q: %v", c.UnusedFor, err)
+	}
+	maxCache := int64(-1)
+	if c.MaxCacheSize != "" {
+		if maxCache = parseSize(c.MaxCacheSize); maxCache < 0 {
+			return fmt.Errorf("invalid --max-cache-size %q", c.MaxCacheSize)
+		}
+	}
+	resources, err := listProjectResources(c.AllProjects)
+	if err != nil {
+		return err
+	}
+
+	remove := map[string]string{}
+	if age > 0 {
+		for _, res := range resources {
+			if !res.Used.IsZero() && time.Since(res.Used) > age {
+				remove[res.Name] = "last used " + formatAgo(res.Used)
+			}
+		}
+	}
+	if maxCache >= 0 {
+		caches := []resource{}
+		for _, res := range resources {
+			if res.Kind == "volume" && res.Owner != "(workspace)" && remove[res.Name] == "" {
+				caches = append(caches, res)
+			}
+		}
+		// Most recently used first; volumes that were never used come last.
+		sort.SliceStable(caches, func(i, j int) bool { return caches[i].Used.After(caches[j].Used) })
+		var total int64
+		for _, res := range caches {
+			if res.Size > 0 {
+				total += res.Size
+			}
+			if total > maxCache {
+				remove[res.Name] = "cache volumes over " + c.MaxCacheSize
+			}
+		}
+	}
+
+	var freed int64
+	for _, res := range resources {
+		reason, ok := remove[res.Name]
+		if !ok {
+			continue
+		}
+		if c.DryRun {
+			log.Printf("Would remove %s %s (%s, %s)\n", res.Kind, res.Name, formatSize(res.Size), reason)
+			continue
+		}
+		log.Printf("Removing %s %s (%s, %s)\n", res.Kind, res.Name, formatSize(res.Size), reason)
+		cmd := rn.Runtime().Command("volume", "rm", res.Name)
+		if res.Kind == "image" {
+			cmd = rn.Runtime().Command("rmi", res.Name)
+		}
+		if err := cmd.Run(); err != nil {
+			log.Printf("Failed to remove %s %s: %v\n", res.Kind, res.Name, err)
+			continue
+		}
+		if res.Size > 0 {
+			freed += res.Size
+		}
+	}
+	if !c.DryRun && len(remove) > 0 {
+		log.Printf("Freed %s\n", formatSize(freed))
+	}
+	return nil
+}
+
+// autoPrune prunes the project after a run if --auto-prune is given.
+// Failures are only logged, since the run itself is done.
+func autoPrune() {
+	if !opts.AutoPrune || interrupted() {
+		return
+	}
+	if err := pruneOpts.Execute(nil); err != nil {
+		log.Printf("Failed to prune: %v\n", err)
+	}
+}
+
+// parseAge parses a duration that may also be given in days, such as 30d.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// makefile is the local path of the build file. It is opts.Makefile, except
+// for remote build files, which are fetched into the cache, and the build
+// file read from standard input, which is stdinName.
+var makefile string
+
+// stdinName is the name of the build file read from standard input with
+// -f -.
+const stdinName = "<stdin>"
+
+// buildFiles are the contents of the build files that are not read from
+// disk, by absolute path: the build file read from standard input.
+var buildFiles map[string]string
+
+// isRemote returns whether the build file name is a URL or a git source.
+func isRemote(name string) bool {
+	return strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "git::")
+}
+
+// remoteCacheDir returns the directory that the remote source key is cached
+// in.
+func remoteCacheDir(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "drmake", "remote", fmt.Sprintf("%x", sha1.Sum([]byte(key)))), nil
+}
+
+// localBuildFile returns the local path of the build file name, fetching
+// remote build files into the cache. A cached build file is used if it
+// cannot be fetched, or without fetching it if it matches --file-sha256.
+// The build file - is read from standard input into buildFiles. The build
+// file must match --file-sha256, if given.
+func localBuildFile(name string) (string, error) {
+	local := name
+	if name == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read the build file from stdin: %v", err)
+		}
+		local = stdinName
+		buildFiles = map[string]string{filepath.Join(origdir, stdinName): string(data)}
+	} else if isRemote(name) {
+		var fetch func() (string, error)
+		if strings.HasPrefix(name, "git::") {
+			fetch = func() (string, error) { return fetchGit(name) }
+		} else {
+			fetch = func() (string, error) { return fetchURL(name) }
+		}
+		var err error
+		if local, err = fetch(); err != nil {
+			return "", fmt.Errorf("Failed to fetch %s: %v", name, err)
+		}
+	}
+	if opts.FileSHA256 != "" {
+		if sum := fileSHA256(local); sum != strings.ToLower(opts.FileSHA256) {
+			return "", fmt.Errorf("%s has SHA-256 checksum %s, expected %s", name, sum, opts.FileSHA256)
+		}
+	}
+	return local, nil
+}
+
+// fileSHA256 returns the hex SHA-256 checksum of the build file name, or an
+// empty string if it cannot be read.
+func fileSHA256(name string) string {
+	data, ok := buildFiles[filepath.Join(origdir, name)]
+	if !ok {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return ""
+		}
+		data = string(b)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+}
+
+// pinnedInCache returns whether the cached file name matches --file-sha256,
+// so that it does not need to be fetched again.
+func pinnedInCache(name string) bool {
+	return opts.FileSHA256 != "" && fileSHA256(name) == strings.ToLower(opts.FileSHA256)
+}
+
+// fetchURL downloads the build file at rawurl into the cache and returns its
+// path.
+func fetchURL(rawurl string) (string, error) {
+	dir, err := remoteCacheDir(rawurl)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = "Makefile.phd"
+	}
+	local := filepath.Join(dir, name)
+	if pinnedInCache(local) {
+		return local, nil
+	}
+
+	data, err := download(rawurl)
+	if err != nil {
+		if _, serr := os.Stat(local); serr == nil {
+			log.Printf("Failed to fetch %s, using the cached copy: %v\n", rawurl, err)
+			return local, nil
+		}
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return local, ioutil.WriteFile(local, data, 0644)
+}
+
+// download returns the body of a GET request for rawurl.
+func download(rawurl string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetchGit checks out the build file of a git source, as in
+// git::github.com/org/repo//path/to/file.phd?ref=v2, into the cache and
+// returns its path. The repository is fetched at ref (its default branch if
+// not given), so that files the build file includes are checked out as
+// well. Repositories without a scheme are fetched over HTTPS, and the path
+// defaults to Makefile.phd.
+func fetchGit(source string) (string, error) {
+	src := strings.TrimPrefix(source, "git::")
+	ref := "HEAD"
+	if i := strings.Index(src, "?"); i >= 0 {
+		query, err := url.ParseQuery(src[i+1:])
+		if err != nil {
+			return "", err
+		}
+		if query.Get("ref") != "" {
+			ref = query.Get("ref")
+		}
+		src = src[:i]
+	}
+	repo, file := src, "Makefile.phd"
+	start := 0
+	if i := strings.Index(src, "://"); i >= 0 {
+		start = i + 3
+	}
+	if i := strings.Index(src[start:], "//"); i >= 0 {
+		repo, file = src[:start+i], src[start+i+2:]
+	}
+	if !strings.Contains(repo, "://") && !strings.Contains(repo, "@") {
+		repo = "https://" + repo
+	}
+
+	dir, err := remoteCacheDir(repo + "?ref=" + ref)
+	if err != nil {
+		return "", err
+	}
+	local := filepath.Join(dir, filepath.FromSlash(file))
+	if pinnedInCache(local) {
+		return local, nil
+	}
+
+	git := func(args ...string) error {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	_, serr := os.Stat(filepath.Join(dir, ".git"))
+	cached := serr == nil
+	if !cached {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		if err := git("init", "-q"); err != nil {
+			return "", err
+		}
+		if err := git("remote", "add", "origin", repo); err != nil {
+			return "", err
+		}
+	}
+	err = git("fetch", "-q", "--depth", "1", "origin", ref)
+	if err == nil {
+		err = git("checkout", "-q", "--force", "FETCH_HEAD")
+	}
+	if err != nil {
+		if cached {
+			if _, serr := os.Stat(local); serr == nil {
+				log.Printf("Failed to fetch %s, using the cached copy: %v\n", source, err)
+				return local, nil
+			}
+		}
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return local, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/graph"
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// checkRequired makes sure that every argument required by the targets to
+// run was given. Missing arguments are prompted for when drmake is attached
+// to a terminal, in which case prompted is true and the build file must be
+// parsed again to expand them.
+func checkRequired(list parser.Targets, runTargetNames []string) (prompted bool, err error) {
+	order, err := graph.ExecOrder(list, runTargetNames)
+	if err != nil {
+		return false, err
+	}
+
+	missing := []string{}
+	var stdin *bufio.Reader
+	for _, t := range order {
+		for _, req := range t.Requires {
+			if _, ok := t.Defaults[req.Name]; ok || parser.HasArg(opts.Args, req.Name) {
+				continue
+			}
+			if !isTerminal(os.Stdin) {
+				missing = append(missing, req.String())
+				continue
+			}
+			if stdin == nil {
+				stdin = bufio.NewReader(os.Stdin)
+			}
+			fmt.Fprintf(os.Stderr, "%s: ", req)
+			value, err := stdin.ReadString('\n')
+			if err != nil {
+				return false, fmt.Errorf("failed to read %s: %v", req.Name, err)
+			}
+			opts.Args = append(opts.Args, req.Name+"="+strings.TrimRight(value, "\r\n"))
+			prompted = true
+		}
+	}
+	if len(missing) > 0 {
+		return false, fmt.Errorf("missing required argument(s), pass them with -a NAME=value: %s", strings.Join(missing, ", "))
+	}
+	return prompted, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+type upCommand struct{}
+
+type downCommand struct{}
+
+type logsCommand struct {
+	Follow bool `short:"f" long:"follow" description:"Follow the log output"`
+
+	Args struct {
+		Service string `positional-arg-name:"SERVICE" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	argparser.AddCommand("up", "Start service targets",
+		"Starts the given service targets, or all of them, and leaves them running until \"drmake down\".",
+		&upCommand{})
+	argparser.AddCommand("down", "Stop service targets",
+		"Stops and removes the containers of all service targets and their network.",
+		&downCommand{})
+	argparser.AddCommand("logs", "Print the logs of a service target",
+		"Prints the logs of a running service target's container.",
+		&logsCommand{})
+}
+
+func (c *upCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	services, err := serviceTargets(list, args)
+	if err != nil {
+		return err
+	}
+	return rn.Up(list, services)
+}
+
+func (c *downCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	services, err := serviceTargets(list, nil)
+	if err != nil {
+		return err
+	}
+	rn.Down(services)
+	return nil
+}
+
+func (c *logsCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	s, err := list.Find(c.Args.Service)
+	if err != nil {
+		return err
+	}
+	if !s.Service {
+		return fmt.Errorf("target %s is not a service", s.Name)
+	}
+	return rn.Logs(s, c.Follow)
+}
+
+// serviceTargets returns the service targets named by names, or all service
+// targets if names is empty.
+func serviceTargets(list parser.Targets, names []string) ([]*parser.Target, error) {
+	services := []*parser.Target{}
+	if len(names) == 0 {
+		for _, s := range list {
+			if s.Service {
+				names = append(names, s.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		s, err := list.Find(name)
+		if err != nil {
+			return nil, err
+		}
+		if !s.Service {
+			return nil, fmt.Errorf("target %s is not a service", s.Name)
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
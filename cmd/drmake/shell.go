@@ -0,0 +1,28 @@
+package main
+
+type shellCommand struct {
+	Shell string `long:"shell" value-name:"PATH" default:"/bin/sh" description:"The shell to run in the container"`
+
+	Args struct {
+		Target string `positional-arg-name:"TARGET" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	argparser.AddCommand("shell", "Open a shell in a target's container",
+		"Builds the target's image and runs an interactive shell in its container, with the workspace, cache and MOUNT volumes mounted as when running the target. "+
+			"Dependencies are not run first.",
+		&shellCommand{})
+}
+
+func (c *shellCommand) Execute(args []string) error {
+	list, _, err := parseMakefile()
+	if err != nil {
+		return err
+	}
+	s, err := list.Find(c.Args.Target)
+	if err != nil {
+		return err
+	}
+	return rn.Shell(list, s, c.Shell)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted is the exit code used when drmake is stopped by a signal.
+const exitInterrupted = 130
+
+// handleSignals stops the in-flight container on SIGINT or SIGTERM. The
+// interrupted command then fails and drmake exits through its normal error
+// path with exitInterrupted.
+func handleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range ch {
+			log.Printf("Received %v, stopping\n", sig)
+			rn.Interrupt()
+		}
+	}()
+}
+
+// interrupted returns whether drmake received SIGINT or SIGTERM.
+func interrupted() bool {
+	return rn.Interrupted()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/runner"
+)
+
+type statsCommand struct {
+	Runs    int  `long:"runs" value-name:"N" default:"20" description:"Only consider the last N runs of each target"`
+	Compare bool `long:"compare" description:"Compare the latest run of each target with the one before it"`
+}
+
+func init() {
+	argparser.AddCommand("stats", "Show how long targets took in past runs",
+		"Shows the number of runs and failures and the latest, average, fastest and slowest duration of the targets in the project's run history (.drmake/history), "+
+			"or only of the given targets. The trend compares the latest run with the average of the ones before it. "+
+			"With --compare, the latest run of each target is compared with the one before it instead.",
+		&statsCommand{})
+}
+
+func (c *statsCommand) Execute(args []string) error {
+	history, err := rn.LoadHistory()
+	if err != nil {
+		return err
+	}
+	only := map[string]bool{}
+	for _, name := range args {
+		only[name] = true
+	}
+
+	// The runs of each target, oldest first.
+	runs := map[string][]runner.HistoryTarget{}
+	names := []string{}
+	for _, run := range history {
+		for _, t := range run.Targets {
+			if len(only) > 0 && !only[t.Target] {
+				continue
+			}
+			if _, ok := runs[t.Target]; !ok {
+				names = append(names, t.Target)
+			}
+			runs[t.Target] = append(runs[t.Target], t)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no runs recorded yet")
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if c.Compare {
+		fmt.Fprintln(w, "TARGET\tPREVIOUS\tLATEST\tCHANGE")
+	} else {
+		fmt.Fprintln(w, "TARGET\tRUNS\tFAILED\tLATEST\tAVERAGE\tFASTEST\tSLOWEST\tTREND")
+	}
+	for _, name := range names {
+		list := runs[name]
+		if c.Runs > 0 && len(list) > c.Runs {
+			list = list[len(list)-c.Runs:]
+		}
+		latest := list[len(list)-1].Duration()
+		if c.Compare {
+			if len(list) < 2 {
+				fmt.Fprintf(w, "%s\t-\t%s\t-\n", name, formatDuration(latest))
+				continue
+			}
+			previous := list[len(list)-2].Duration()
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, formatDuration(previous), formatDuration(latest), formatChange(previous, latest))
+			continue
+		}
+
+		failed := 0
+		var total, fastest, slowest time.Duration
+		for i, t := range list {
+			d := t.Duration()
+			if t.Status == "failed" {
+				failed++
+			}
+			total += d
+			if i == 0 || d < fastest {
+				fastest = d
+			}
+			if d > slowest {
+				slowest = d
+			}
+		}
+		trend := "-"
+		if len(list) > 1 {
+			trend = formatChange((total-latest)/time.Duration(len(list)-1), latest)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", name, len(list), failed, formatDuration(latest),
+			formatDuration(total/time.Duration(len(list))), formatDuration(fastest), formatDuration(slowest), trend)
+	}
+	return w.Flush()
+}
+
+// formatDuration formats a duration rounded to a tenth of a second.
+func formatDuration(d time.Duration) string {
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// formatChange formats the relative change from before to after.
+func formatChange(before, after time.Duration) string {
+	if before == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.0f%%", (float64(after)/float64(before)-1)*100)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/runner"
+)
+
+type statusCommand struct {
+	AllProjects bool `long:"all-projects" description:"List the volumes and images of every drmake project on this machine"`
+}
+
+func init() {
+	argparser.AddCommand("status", "List the project's volumes and images",
+		"Lists the workspace volume, the cache volumes and the target images of the project with their size, "+
+			"when they were last used and what they belong to. "+
+			"With --all-projects, the resources of every drmake project are listed along with their project directory.",
+		&statusCommand{})
+}
+
+// resource is a volume or image of a drmake project.
+type resource struct {
+	Kind    string // "volume" or "image"
+	Name    string
+	Owner   string // the target, or the role of a volume
+	Size    int64  // in bytes, or -1 if unknown
+	Used    time.Time
+	Project string
+}
+
+func (c *statusCommand) Execute(args []string) error {
+	resources, err := listProjectResources(c.AllProjects)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "TYPE\tNAME\tTARGET\tSIZE\tLAST USED"
+	if c.AllProjects {
+		header += "\tPROJECT"
+	}
+	fmt.Fprintln(w, header)
+	var total int64
+	for _, res := range resources {
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", res.Kind, res.Name, res.Owner, formatSize(res.Size), formatAgo(res.Used))
+		if c.AllProjects {
+			line += "\t" + res.Project
+		}
+		fmt.Fprintln(w, line)
+		if res.Size > 0 {
+			total += res.Size
+		}
+	}
+	w.Flush()
+	fmt.Printf("\n%d volumes and images using %s\n", len(resources), formatSize(total))
+	return nil
+}
+
+// listProjectResources returns the volumes and images of the project, or
+// of every project if all is set, sorted by kind and name. Resources never
+// recorded in the usage record get their creation time, if known, as their
+// last use.
+func listProjectResources(all bool) ([]resource, error) {
+	owner := &cleanCommand{All: all}
+	usage, err := runner.LoadUsage()
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := listResources("volume", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return nil, err
+	}
+	images, err := listResources("images", "--format", "{{.Repository}}:{{.Tag}}\t{{.Size}}\t{{.CreatedAt}}")
+	if err != nil {
+		return nil, err
+	}
+	sizes := volumeSizes()
+
+	resources := []resource{}
+	for _, vol := range volumes {
+		if !owner.ownsVolume(vol) {
+			continue
+		}
+		res := resource{Kind: "volume", Name: vol, Owner: volumeRole(vol), Size: -1, Project: "-"}
+		if size, ok := sizes[vol]; ok {
+			res.Size = size
+		}
+		if use, ok := usage[vol]; ok {
+			res.Used, res.Project = use.Time, use.Dir
+		}
+		resources = append(resources, res)
+	}
+	for _, line := range images {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || !owner.ownsImage(fields[0]) {
+			continue
+		}
+		name := strings.TrimPrefix(fields[0], "localhost/")
+		repo := name
+		if i := strings.LastIndex(repo, ":"); i > strings.Index(repo, "/") {
+			repo = repo[:i]
+		}
+		res := resource{Kind: "image", Name: name, Owner: repo[strings.Index(repo, "/")+1:], Size: parseSize(fields[1]), Project: "-"}
+		if use, ok := usage[repo]; ok {
+			res.Used, res.Project = use.Time, use.Dir
+		} else if t, err := time.Parse("2006-01-02 15:04:05 -0700 MST", fields[2]); err == nil {
+			res.Used = t
+		}
+		resources = append(resources, res)
+	}
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].Kind != resources[j].Kind {
+			return resources[i].Kind > resources[j].Kind
+		}
+		return resources[i].Name < resources[j].Name
+	})
+	return resources, nil
+}
+
+// volumeRole returns what a drmake volume is used for.
+func volumeRole(vol string) string {
+	cache := rn.VolumeName("cache")
+	switch {
+	case vol == rn.VolumeName("ws") || strings.HasPrefix(vol, "drmake-ws-") || vol == rn.LegacyVolumeName("ws"):
+		return "(workspace)"
+	case strings.HasPrefix(vol, cache+"-") || (strings.HasPrefix(vol, "drmake-cache-") && strings.Count(vol, "-") > 2):
+		return "(CACHE)"
+	default:
+		return "(cache)"
+	}
+}
+
+// volumeSizes returns the disk usage of every volume in bytes. The runtime
+// only reports it in its verbose disk usage summary; if that fails, no
+// sizes are known.
+func volumeSizes() map[string]int64 {
+	sizes := map[string]int64{}
+	out, err := rn.Runtime().Command("system", "df", "-v", "--format", "{{json .}}").Output()
+	if err != nil {
+		return sizes
+	}
+	var df struct {
+		Volumes []struct {
+			Name string
+			Size string
+		}
+	}
+	if err := json.Unmarshal(out, &df); err != nil {
+		return sizes
+	}
+	for _, vol := range df.Volumes {
+		sizes[vol.Name] = parseSize(vol.Size)
+	}
+	return sizes
+}
+
+// parseSize parses a size as printed by the runtime, such as 1.5GB, into
+// bytes. It returns -1 if the size cannot be parsed.
+func parseSize(s string) int64 {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return -1
+	}
+	units := map[string]float64{"": 1, "B": 1, "KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+		"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40}
+	unit, ok := units[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return -1
+	}
+	return int64(n * unit)
+}
+
+// formatSize formats a size in bytes like the runtime does.
+func formatSize(n int64) string {
+	if n < 0 {
+		return "-"
+	}
+	f, unit := float64(n), "B"
+	for _, u := range []string{"kB", "MB", "GB", "TB"} {
+		if f < 1000 {
+			break
+		}
+		f, unit = f/1000, u
+	}
+	return strconv.FormatFloat(f, 'g', 3, 64) + unit
+}
+
+// formatAgo formats the time t relative to now, or "-" if it is unknown.
+func formatAgo(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	}
+}
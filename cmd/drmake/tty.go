@@ -0,0 +1,21 @@
+package main
+
+import "os"
+
+// isTerminal returns whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// interactive returns whether containers should be run with an interactive
+// TTY attached, which is only possible when drmake itself is attached to one.
+func interactive() bool {
+	if opts.NoInteractive {
+		return false
+	}
+	if opts.Interactive {
+		return true
+	}
+	return isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+	"github.com/lsegal/drmake/pkg/runner"
+)
+
+// watch runs the targets, then polls the project directory and runs them
+// again whenever a file that is not ignored changes. Target failures are
+// logged and do not stop watching.
+func watch(list parser.Targets, runTargetNames []string) error {
+	ignore := append(runner.IgnoreList{".git"}, runner.LoadIgnore(origdir)...)
+	for {
+		if err := rn.Run(list, runTargetNames); err != nil {
+			if interrupted() {
+				return err
+			}
+			log.Print(err)
+		}
+
+		// Snapshot after running so that artifacts copied back into the
+		// project do not trigger another run.
+		last := snapshot(ignore)
+		log.Printf("Watching %s for changes\n", origdir)
+		for snapshot(ignore) == last {
+			if interrupted() {
+				return runner.ErrInterrupted
+			}
+			time.Sleep(opts.WatchInterval)
+		}
+		log.Println("Change detected, re-running targets")
+	}
+}
+
+// snapshot returns a hash of the path, size and modification time of every
+// file in the project directory that is not ignored.
+func snapshot(ignore runner.IgnoreList) string {
+	h := sha1.New()
+	filepath.Walk(origdir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(origdir, name)
+		if rel != "." && info.IsDir() && ignore.MatchDir(filepath.ToSlash(rel)) {
+			return filepath.SkipDir
+		} else if rel != "." && !info.IsDir() && ignore.Match(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if !info.IsDir() {
+			fmt.Fprintf(h, "%s\x00%d\x00%d\x00", rel, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -2,4 +2,7 @@ module github.com/lsegal/drmake
 
 go 1.12
 
-require github.com/jessevdk/go-flags v1.4.0
+require (
+	github.com/jessevdk/go-flags v1.4.0
+	gopkg.in/yaml.v2 v2.4.0
+)
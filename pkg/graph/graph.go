@@ -0,0 +1,124 @@
+// Package graph resolves and renders the dependencies between targets.
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// ExecOrder returns the targets named by names and all of their
+// dependencies, in the order they must run. Each target appears once. It is
+// an error for a target to depend on itself, directly or not.
+func ExecOrder(list parser.Targets, names []string) (out []*parser.Target, err error) {
+	return execOrder(list, names, nil)
+}
+
+// execOrder is ExecOrder for the dependencies of the targets in path, which
+// are being resolved.
+func execOrder(list parser.Targets, names []string, path []string) (out []*parser.Target, err error) {
+	unordTargets := []string{}
+	ordTargets := map[string]int{}
+
+	for _, targName := range names {
+		target, err := list.Find(targName)
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range path {
+			if name == targName {
+				cycle := append(append([]string{}, path[i:]...), targName)
+				return nil, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		depTargets, err := execOrder(list, target.Deps, append(path[:len(path):len(path)], targName))
+		if err != nil {
+			return nil, err
+		}
+		depTargetNames := make([]string, len(depTargets))
+		for i, s := range depTargets {
+			depTargetNames[i] = s.Name
+		}
+		unordTargets = append(unordTargets, append(append([]string{}, depTargetNames...), targName)...)
+	}
+
+	n := 0
+	for _, name := range unordTargets {
+		if ordTargets[name] != 0 {
+			continue
+		}
+
+		n++
+		ordTargets[name] = n
+	}
+
+	out = make([]*parser.Target, len(ordTargets))
+	for name, idx := range ordTargets {
+		out[idx-1] = list[name]
+	}
+
+	return
+}
+
+// Parent returns the name of the target that t inherits its Dockerfile from
+// via FROM #target, or an empty string.
+func Parent(t *parser.Target) string {
+	if strings.HasPrefix(t.Image, "#") && t.Image[1:] != t.Name {
+		return t.Image[1:]
+	}
+	return ""
+}
+
+// header returns how the target is declared, for node labels.
+func header(t *parser.Target) string {
+	if t.Phony {
+		return "TARGET"
+	}
+	return "FROM " + t.Image
+}
+
+// WriteDot writes the graph of targets to w in Graphviz DOT format.
+func WriteDot(w io.Writer, targets []*parser.Target) {
+	fmt.Fprintln(w, "digraph drmake {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	for _, t := range targets {
+		fmt.Fprintf(w, "\t%q [label=%q];\n", t.Name, t.Name+"\n"+header(t))
+	}
+	for _, t := range targets {
+		for _, dep := range t.Deps {
+			fmt.Fprintf(w, "\t%q -> %q;\n", dep, t.Name)
+		}
+		if parent := Parent(t); parent != "" {
+			fmt.Fprintf(w, "\t%q -> %q [style=dashed];\n", parent, t.Name)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// WriteMermaid writes the graph of targets to w as a Mermaid flowchart.
+func WriteMermaid(w io.Writer, targets []*parser.Target) {
+	ids := map[string]string{}
+	id := func(name string) string {
+		if ids[name] == "" {
+			ids[name] = fmt.Sprintf("n%d", len(ids))
+		}
+		return ids[name]
+	}
+
+	fmt.Fprintln(w, "graph LR")
+	for _, t := range targets {
+		label := strings.Replace(t.Name+"<br/>"+header(t), `"`, "#quot;", -1)
+		fmt.Fprintf(w, "\t%s[\"%s\"]\n", id(t.Name), label)
+	}
+	for _, t := range targets {
+		for _, dep := range t.Deps {
+			fmt.Fprintf(w, "\t%s --> %s\n", id(dep), id(t.Name))
+		}
+		if parent := Parent(t); parent != "" {
+			fmt.Fprintf(w, "\t%s -.-> %s\n", id(parent), id(t.Name))
+		}
+	}
+}
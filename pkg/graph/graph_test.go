@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// targets returns a list of targets from names and their dependencies.
+func targets(deps map[string][]string) parser.Targets {
+	list := parser.Targets{}
+	for name, d := range deps {
+		list[name] = &parser.Target{Name: name, Deps: d}
+	}
+	return list
+}
+
+func TestExecOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		deps  map[string][]string
+		names []string
+		want  []string
+		err   string
+	}{
+		{"single", map[string][]string{"a": nil}, []string{"a"}, []string{"a"}, ""},
+		{"chain", map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil}, []string{"a"}, []string{"c", "b", "a"}, ""},
+		{"shared", map[string][]string{"a": {"b", "c"}, "b": {"c"}, "c": nil}, []string{"a"}, []string{"c", "b", "a"}, ""},
+		{"several", map[string][]string{"a": {"c"}, "b": {"c"}, "c": nil}, []string{"b", "a"}, []string{"c", "b", "a"}, ""},
+		{"unknown", map[string][]string{"a": {"x"}}, []string{"a"}, nil, "Unknown target: x"},
+		{"self", map[string][]string{"a": {"a"}}, []string{"a"}, nil, "dependency cycle: a -> a"},
+		{"cycle", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}}, []string{"a"}, nil, "dependency cycle: b -> c -> b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := ExecOrder(targets(tt.deps), tt.names)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := []string{}
+			for _, s := range order {
+				got = append(got, s.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got order %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package parser
+
+import "strings"
+
+// Artifact is a file or directory copied out of the workspace volume back
+// to the project directory after a target runs.
+type Artifact struct {
+	Src     string
+	Dst     string
+	Exclude []string
+
+	// Image is set for ARTIFACT IMAGE, whose source is an absolute path in
+	// the target's image rather than a path in the workspace volume.
+	Image bool
+
+	// Line is the line of the ARTIFACT directive.
+	Line int
+}
+
+// ParseArtifact parses the arguments of an ARTIFACT directive:
+//
+//	ARTIFACT [IMAGE] src [dst] [EXCLUDE pattern,pattern...]
+//	ARTIFACT [IMAGE] src=dst [EXCLUDE pattern,pattern...]
+func ParseArtifact(args []string) Artifact {
+	a := Artifact{}
+	if len(args) > 1 && strings.ToUpper(args[0]) == "IMAGE" {
+		a.Image = true
+		args = args[1:]
+	}
+	for i, arg := range args {
+		if strings.ToUpper(arg) == "EXCLUDE" {
+			for _, pattern := range strings.Split(strings.Join(args[i+1:], ","), ",") {
+				if pattern != "" {
+					a.Exclude = append(a.Exclude, pattern)
+				}
+			}
+			args = args[:i]
+			break
+		}
+	}
+
+	artargs := strings.Join(args, " ")
+	splitchr := " "
+	if strings.Contains(artargs, "=") {
+		splitchr = "="
+	}
+
+	s := strings.SplitN(artargs, splitchr, 2)
+	a.Src = s[0]
+	if len(s) == 2 {
+		a.Dst = s[1]
+	} else {
+		a.Dst = s[0]
+	}
+	return a
+}
+
+// checkArtifacts records an error for every artifact destination that still
+// references a variable once all variables have been expanded, which would
+// otherwise be copied to a path containing the reference itself. Escaped
+// dollar signs ($$) are then replaced by literal ones in artifact paths.
+func (p *parser) checkArtifacts() {
+	for _, t := range p.list.Sorted() {
+		for i, a := range t.Artifacts {
+			for _, m := range reVariable.FindAllStringSubmatch(a.Dst, -1) {
+				if name := m[1] + m[2]; name != "" {
+					p.errorf(t.File, a.Line, "undefined variable %s in ARTIFACT destination %s (pass it with -a %s=value)", name, a.Dst, name)
+					break
+				}
+			}
+			t.Artifacts[i].Src = strings.Replace(a.Src, "$$", "$", -1)
+			t.Artifacts[i].Dst = strings.Replace(a.Dst, "$$", "$", -1)
+		}
+	}
+}
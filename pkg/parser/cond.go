@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// cond is an IF block that is being parsed.
+type cond struct {
+	// line is the line of the IF.
+	line int
+
+	// parent is whether the lines around the block are parsed, active
+	// whether the lines of the current branch are, and taken whether any
+	// branch so far was.
+	parent bool
+	active bool
+	taken  bool
+
+	// inElse is set after a plain ELSE, which must be the last branch.
+	inElse bool
+}
+
+// conditionVars are the variables that are only available in IF conditions,
+// after -a args, DEFAULT values and built-ins but before the environment.
+var conditionVars = map[string]string{
+	"OS":   runtime.GOOS,
+	"ARCH": runtime.GOARCH,
+}
+
+// conditional handles the IF, ELSE and ENDIF lines of a build file. It
+// returns whether the line was one of them, and keeps track of whether the
+// following lines are parsed in active. Conditions are evaluated in the
+// context of target t, which may be nil.
+func (p *parser) conditional(stack *[]cond, active *bool, ln line, t *Target, errorf func(format string, args ...interface{})) bool {
+	c, _ := fields(ln.text)
+	keyword := strings.ToUpper(c[0])
+	if keyword == "ELSE" && len(c) > 1 && strings.EqualFold(c[1], "IF") {
+		keyword, c = "ELSE IF", c[1:]
+	}
+
+	switch keyword {
+	case "IF":
+		b := cond{line: ln.num, parent: *active}
+		if *active {
+			value, err := p.evalCondition(c[1:], t)
+			if err != nil {
+				errorf("%v", err)
+			}
+			b.active, b.taken = value, value
+		}
+		*stack = append(*stack, b)
+
+	case "ELSE", "ELSE IF":
+		if len(*stack) == 0 {
+			errorf("%s without IF", keyword)
+			return true
+		}
+		b := &(*stack)[len(*stack)-1]
+		if b.inElse {
+			errorf("%s after ELSE, the IF at line %d already has an ELSE", keyword, b.line)
+			return true
+		}
+		value := true
+		if keyword == "ELSE" {
+			b.inElse = true
+		} else if b.parent && !b.taken {
+			var err error
+			if value, err = p.evalCondition(c[1:], t); err != nil {
+				errorf("%v", err)
+			}
+		}
+		b.active = b.parent && !b.taken && value
+		b.taken = b.taken || b.active
+
+	case "ENDIF":
+		if len(*stack) == 0 {
+			errorf("ENDIF without IF")
+			return true
+		}
+		*stack = (*stack)[:len(*stack)-1]
+
+	default:
+		return false
+	}
+
+	*active = true
+	if n := len(*stack); n > 0 {
+		*active = (*stack)[n-1].active
+	}
+	return true
+}
+
+// evalCondition evaluates the arguments of an IF: a value, which is true
+// unless it is empty, false or 0, or a comparison of two values with == or
+// !=. Values may be quoted, and variables in them that are not set expand
+// to empty strings.
+func (p *parser) evalCondition(args []string, t *Target) (bool, error) {
+	value := func(s string) string {
+		s = reVariable.ReplaceAllStringFunc(s, func(ref string) string {
+			m := reVariable.FindStringSubmatch(ref)
+			name := m[1] + m[2]
+			if name == "" {
+				return ref
+			}
+			if value, ok := p.lookupVar(name, t, false); ok {
+				return value
+			}
+			if value, ok := conditionVars[name]; ok {
+				return value
+			}
+			return os.Getenv(name)
+		})
+		if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+			s = s[1 : len(s)-1]
+		}
+		return s
+	}
+
+	switch {
+	case len(args) == 1:
+		v := value(args[0])
+		return v != "" && v != "false" && v != "0", nil
+	case len(args) == 3 && args[1] == "==":
+		return value(args[0]) == value(args[2]), nil
+	case len(args) == 3 && args[1] == "!=":
+		return value(args[0]) != value(args[2]), nil
+	}
+	return false, fmt.Errorf("IF requires a condition, as in IF ${NAME} == value, got %s", strings.Join(args, " "))
+}
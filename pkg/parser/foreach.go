@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var reForeach = regexp.MustCompile(`(?i)^FOREACH\s+(\w+)\s+IN\s+(.+)$`)
+
+// expandLoops replaces each FOREACH name IN values... block of lines, up to
+// its ENDFOR, with a copy of its lines for every value, in which $name and
+// ${name} are replaced by the value. Values may reference variables, and
+// loops may be nested.
+func (p *parser) expandLoops(filename string, lines []line) []line {
+	out := []line{}
+	for i := 0; i < len(lines); i++ {
+		ln := lines[i]
+		switch strings.ToUpper(strings.Fields(ln.text)[0]) {
+		case "FOREACH":
+		case "ENDFOR":
+			p.errorf(filename, ln.num, "ENDFOR without FOREACH")
+			continue
+		default:
+			out = append(out, ln)
+			continue
+		}
+
+		// Find the matching ENDFOR.
+		depth, end := 1, -1
+		for j := i + 1; j < len(lines) && end < 0; j++ {
+			switch strings.ToUpper(strings.Fields(lines[j].text)[0]) {
+			case "FOREACH":
+				depth++
+			case "ENDFOR":
+				if depth--; depth == 0 {
+					end = j
+				}
+			}
+		}
+		if end < 0 {
+			p.errorf(filename, ln.num, "FOREACH without ENDFOR")
+			return out
+		}
+		body := lines[i+1 : end]
+		i = end
+
+		m := reForeach.FindStringSubmatch(ln.text)
+		if m == nil {
+			p.errorf(filename, ln.num, "FOREACH requires a name and values, as in FOREACH name IN value...")
+			continue
+		}
+		name := m[1]
+		for _, value := range strings.Fields(p.expand(m[2], nil, true)) {
+			iteration := make([]line, len(body))
+			for j, bl := range body {
+				iteration[j] = line{num: bl.num, text: reVariable.ReplaceAllStringFunc(bl.text, func(ref string) string {
+					if r := reVariable.FindStringSubmatch(ref); r[1]+r[2] == name {
+						return value
+					}
+					return ref
+				})}
+			}
+			out = append(out, p.expandLoops(filename, iteration)...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// continuationIndent is the indentation of the continued lines of an
+// instruction.
+const continuationIndent = "    "
+
+// Format returns the build file src in canonical form: keywords are upper
+// case, each instruction starts at the beginning of its line with a single
+// space after the keyword, continued lines are indented by four spaces,
+// consecutive ARTIFACT lines are sorted, and targets are separated by a
+// single blank line. Comments are kept with the lines that follow them, and
+// trailing whitespace and repeated blank lines are removed.
+func Format(src string) string {
+	// Each unit is a comment, a blank line or an instruction with its
+	// continued lines.
+	units := [][]string{}
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		text := strings.TrimSpace(lines[i])
+		switch {
+		case text == "":
+			units = append(units, nil)
+		case text[0] == '#':
+			units = append(units, []string{text})
+		default:
+			unit := []string{formatInstruction(text)}
+			for isContinued(text) && i+1 < len(lines) {
+				i++
+				text = strings.TrimSpace(lines[i])
+				unit = append(unit, continuationIndent+text)
+			}
+			for j := range unit {
+				unit[j] = formatContinuation(unit[j])
+			}
+			units = append(units, unit)
+		}
+	}
+
+	out := []string{}
+	for i := 0; i < len(units); i++ {
+		unit := units[i]
+		switch {
+		case unit == nil:
+			if len(out) > 0 && out[len(out)-1] != "" {
+				out = append(out, "")
+			}
+			continue
+		case startsTarget(unit[0]):
+			// The blank line goes before the comments of the target.
+			start := len(out)
+			for start > 0 && strings.HasPrefix(out[start-1], "#") {
+				start--
+			}
+			if start > 0 && out[start-1] != "" {
+				out = append(out[:start], append([]string{""}, out[start:]...)...)
+			}
+		case keyword(unit[0]) == "ARTIFACT":
+			run := [][]string{unit}
+			for i+1 < len(units) && units[i+1] != nil && keyword(units[i+1][0]) == "ARTIFACT" {
+				i++
+				run = append(run, units[i])
+			}
+			sort.SliceStable(run, func(a, b int) bool {
+				return strings.Join(run[a], "\n") < strings.Join(run[b], "\n")
+			})
+			for _, unit := range run {
+				out = append(out, unit...)
+			}
+			continue
+		}
+		out = append(out, unit...)
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// isContinued returns whether the line continues on the next line, as lex
+// joins them.
+func isContinued(text string) bool {
+	return strings.HasSuffix(text, " \\")
+}
+
+// formatContinuation puts a single space before the backslash of a
+// continued line.
+func formatContinuation(text string) string {
+	if !isContinued(text) {
+		return text
+	}
+	return strings.TrimRight(text[:len(text)-1], " \t") + " \\"
+}
+
+// keyword returns the upper case keyword of an instruction.
+func keyword(text string) string {
+	if f := strings.Fields(text); len(f) > 0 {
+		return strings.ToUpper(f[0])
+	}
+	return ""
+}
+
+// startsTarget returns whether the instruction declares a target or ends
+// the current one.
+func startsTarget(text string) bool {
+	switch keyword(text) {
+	case "FROM", "SERVICE", "TARGET", "INCLUDE", "SUBDIR":
+		return true
+	}
+	return false
+}
+
+// formatInstruction upper cases the keyword of a known instruction and the
+// AS and USING keywords of target declarations, and separates the keyword
+// from its arguments with a single space.
+func formatInstruction(text string) string {
+	kw := keyword(text)
+	if !directives[kw] && !instructions[kw] {
+		return text
+	}
+	rest := strings.TrimSpace(text[len(strings.Fields(text)[0]):])
+	switch kw {
+	case "FROM", "SERVICE", "TARGET":
+		words := strings.Fields(rest)
+		for i, word := range words {
+			upper := strings.ToUpper(word)
+			if i == 1 && upper == "AS" && kw != "TARGET" || i > 0 && upper == "USING" {
+				words[i] = upper
+			}
+			if upper == "USING" {
+				break
+			}
+		}
+		rest = strings.Join(words, " ")
+	}
+	if rest == "" {
+		return kw
+	}
+	return kw + " " + rest
+}
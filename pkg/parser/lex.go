@@ -0,0 +1,61 @@
+package parser
+
+import "strings"
+
+// line is a logical line of a build file, with continuations joined.
+type line struct {
+	// num is the number of the physical line it starts on.
+	num  int
+	text string
+}
+
+// lex splits a build file into logical lines, dropping blank lines and
+// comments. Lines ending in " \" continue on the next line.
+func lex(data string) []line {
+	lines := []line{}
+	prev, start := "", 0
+	for i, text := range strings.Split(data, "\n") {
+		if prev == "" {
+			start = i + 1
+		}
+		text = prev + strings.TrimSpace(text)
+		if strings.HasSuffix(text, " \\") {
+			prev = text[0 : len(text)-1]
+			continue
+		}
+		prev = ""
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		lines = append(lines, line{num: start, text: text})
+	}
+	if prev = strings.TrimSpace(prev); prev != "" && prev[0] != '#' {
+		lines = append(lines, line{num: start, text: prev})
+	}
+	return lines
+}
+
+// fields splits a line into whitespace separated words. Double quoted
+// strings are kept in a single word, quotes included. ok is false if a
+// quote is not terminated.
+func fields(s string) (words []string, ok bool) {
+	word, inword, quoted := "", false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t'):
+			if inword {
+				words = append(words, word)
+				word, inword = "", false
+			}
+			continue
+		}
+		word += string(r)
+		inword = true
+	}
+	if inword {
+		words = append(words, word)
+	}
+	return words, !quoted
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// instructions are the Dockerfile instructions that may appear in a target.
+var instructions = map[string]bool{
+	"ADD":         true,
+	"ARG":         true,
+	"CMD":         true,
+	"COPY":        true,
+	"COPYFROM":    true,
+	"ENTRYPOINT":  true,
+	"ENV":         true,
+	"ENVARG":      true,
+	"EXPOSE":      true,
+	"HEALTHCHECK": true,
+	"LABEL":       true,
+	"MAINTAINER":  true,
+	"ONBUILD":     true,
+	"RUN":         true,
+	"SHELL":       true,
+	"STOPSIGNAL":  true,
+	"USER":        true,
+	"VOLUME":      true,
+	"WORKDIR":     true,
+}
+
+// Lint parses the build file filename like ParseFile and additionally checks
+// it for likely mistakes: unknown instructions, duplicate target names,
+// dependencies on targets that do not exist, dependency cycles, targets that
+// are never used and artifacts copied outside of the project directory. All problems found are
+// returned as an ErrorList, or nil if there are none.
+//
+// A target is considered used if it is the default target, another target
+// depends on it, builds on it with FROM #target or runs it with ONFAILURE or
+// ALWAYS, or it has a description or is expanded from a pattern target and
+// is therefore meant to be run from the command line.
+func Lint(filename string, opts Options) error {
+	p := &parser{opts: opts, list: Targets{}, included: map[string]bool{}, lint: true, strict: opts.Strict}
+	defaultTarget, err := p.parse(filename)
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{defaultTarget: true}
+	for _, t := range p.list.Sorted() {
+		refs := append(append(append([]string{}, t.Deps...), t.OnFailure...), t.Always...)
+		if strings.HasPrefix(t.Image, "#") {
+			refs = append([]string{t.Image[1:]}, refs...)
+		}
+		for _, name := range refs {
+			if p.list[name] == nil {
+				p.errorf(t.File, t.Line, "target %s refers to unknown target %s", t.Name, name)
+			} else if name != t.Name {
+				used[name] = true
+			}
+		}
+	}
+	p.checkCycles()
+	for _, t := range p.list.Sorted() {
+		if !used[t.Name] && t.Desc == "" && t.Pattern == "" {
+			p.errorf(t.File, t.Line, "target %s is never used", t.Name)
+		}
+	}
+
+	if len(p.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(p.errs, func(i, j int) bool {
+		a, b := p.errs[i], p.errs[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return p.errs
+}
+
+// outsideDir returns whether the relative path dst points outside of the
+// directory it is relative to.
+func outsideDir(dst string) bool {
+	dst = filepath.Clean(filepath.FromSlash(dst))
+	return filepath.IsAbs(dst) || dst == ".." || strings.HasPrefix(dst, ".."+string(filepath.Separator))
+}
+
+// checkCycles records an error for every cycle of targets that depend on or
+// build on (FROM #target) each other, at the first target of the cycle.
+func (p *parser) checkCycles() {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(t *Target)
+	visit = func(t *Target) {
+		state[t.Name] = visiting
+		path = append(path, t.Name)
+		refs := t.Deps
+		if strings.HasPrefix(t.Image, "#") && t.Image[1:] != t.Name {
+			refs = append([]string{t.Image[1:]}, refs...)
+		}
+		for _, name := range refs {
+			switch dep := p.list[name]; {
+			case dep == nil || state[name] == done:
+			case state[name] == visiting:
+				for i := range path {
+					if path[i] == name {
+						first := p.list[path[i]]
+						cycle := append(append([]string{}, path[i:]...), name)
+						p.errorf(first.File, first.Line, "dependency cycle: %s", strings.Join(cycle, " -> "))
+						break
+					}
+				}
+			default:
+				visit(dep)
+			}
+		}
+		path = path[:len(path)-1]
+		state[t.Name] = done
+	}
+	for _, t := range p.list.Sorted() {
+		if state[t.Name] == 0 {
+			visit(t)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"clean", "FROM alpine AS a USING b\nFROM alpine AS b\n", nil},
+		{"unknown dependency", "FROM alpine AS a USING x\n", []string{"target a refers to unknown target x"}},
+		{"unused target", "FROM alpine AS a\nFROM alpine AS b\n", []string{"target b is never used"}},
+		{"self dependency", "FROM alpine AS a USING a\n", []string{"dependency cycle: a -> a"}},
+		{"cycle", "FROM alpine AS a USING b\nFROM alpine AS b USING c\nFROM alpine AS c USING b\n", []string{"Makefile.phd:2: dependency cycle: b -> c -> b"}},
+		{"image cycle", "FROM #b AS a\nFROM #a AS b\n", []string{"Makefile.phd:1: dependency cycle: a -> b -> a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeProject(t, map[string]string{"Makefile.phd": tt.src})
+			defer os.RemoveAll(dir)
+			err := Lint(filepath.Join(dir, "Makefile.phd"), Options{Dir: dir})
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDockerfileCycle(t *testing.T) {
+	list, _, err := parseProject(t, map[string]string{"Makefile.phd": "FROM #b AS a\nFROM #c AS b\nFROM #a AS c\n"}, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "dependency cycle: a -> b -> c -> a"
+	if _, err := list["a"].Dockerfile(list, ""); err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// axis is a MATRIX argument and the values it takes.
+type axis struct {
+	name   string
+	values []string
+}
+
+// parseMatrix parses the NAME=value,value... arguments of a MATRIX
+// directive.
+func parseMatrix(args []string) ([]axis, error) {
+	axes := []axis{}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("MATRIX requires NAME=value,value... arguments, got %s", arg)
+		}
+		axes = append(axes, axis{name: kv[0], values: strings.Split(kv[1], ",")})
+	}
+	return axes, nil
+}
+
+// variants returns the cross product of the axes' values, varying the last
+// axis fastest.
+func variants(axes []axis) []map[string]string {
+	list := []map[string]string{{}}
+	for _, a := range axes {
+		next := []map[string]string{}
+		for _, v := range list {
+			for _, value := range a.values {
+				m := map[string]string{a.name: value}
+				for k, val := range v {
+					m[k] = val
+				}
+				next = append(next, m)
+			}
+		}
+		list = next
+	}
+	return list
+}
+
+// expandMatrices replaces each target with a MATRIX by a target for every
+// combination of the matrix values, named after the target and the values
+// (build-linux-amd64). The values are substituted for references to the
+// matrix arguments and passed as build args. Artifacts whose destination
+// does not reference a matrix argument are copied into a directory named
+// after the values. The original target is replaced by a TARGET that only
+// depends on its variants.
+func (p *parser) expandMatrices() {
+	for _, mt := range p.list.Sorted() {
+		if len(mt.matrix) == 0 {
+			continue
+		}
+		agg := &Target{
+			Name:      mt.Name,
+			Phony:     true,
+			Desc:      mt.Desc,
+			Retries:   -1,
+			File:      mt.File,
+			Line:      mt.Line,
+			Dir:       mt.Dir,
+			Namespace: mt.Namespace,
+			Pattern:   mt.Pattern,
+			Internal:  mt.Internal,
+			order:     mt.order,
+			strict:    mt.strict,
+		}
+		p.list[mt.Name] = agg
+
+		for _, values := range variants(mt.matrix) {
+			suffix := []string{}
+			for _, a := range mt.matrix {
+				suffix = append(suffix, values[a.name])
+			}
+			variant := strings.Join(suffix, "-")
+
+			t := mt.substituted(func(s string) string {
+				return reVariable.ReplaceAllStringFunc(s, func(ref string) string {
+					m := reVariable.FindStringSubmatch(ref)
+					if value, ok := values[m[1]+m[2]]; ok {
+						return value
+					}
+					return ref
+				})
+			})
+			t.Name = mt.Name + "-" + variant
+			t.matrix = nil
+			if t.Defaults == nil {
+				t.Defaults = map[string]string{}
+			}
+			for name, value := range values {
+				t.Defaults[name] = value
+			}
+			for i, a := range t.Artifacts {
+				if a.Dst == mt.Artifacts[i].Dst && a.Dst != "-" {
+					t.Artifacts[i].Dst = variantPath(a.Dst, variant)
+				}
+			}
+			if p.list[t.Name] == nil {
+				p.list[t.Name] = t
+				agg.Deps = append(agg.Deps, t.Name)
+			}
+		}
+	}
+}
+
+// variantPath places the artifact destination dst in a directory named
+// after the variant: dist/app becomes dist/variant/app and dist/ becomes
+// dist/variant/.
+func variantPath(dst, variant string) string {
+	if strings.HasSuffix(dst, "/") {
+		return dst + variant + "/"
+	}
+	scheme := ""
+	if i := strings.Index(dst, "://"); i >= 0 {
+		scheme, dst = dst[:i+3], dst[i+3:]
+	}
+	return scheme + path.Join(path.Dir(dst), variant, path.Base(dst))
+}
@@ -0,0 +1,54 @@
+package parser
+
+import "strings"
+
+// qualify returns the name of a target declared as name in the namespace
+// of the build file being parsed.
+func (p *parser) qualify(name string) string {
+	if p.ns == "" {
+		return name
+	}
+	return p.ns + ":" + name
+}
+
+// ref returns the name of the target referenced as name from the namespace
+// of the build file being parsed. A leading colon refers to a target of the
+// main project, as in :build, and a name whose part before the colon is a
+// namespace nested in the current one, as in docs:spelling, to a target of
+// that namespace. Other names, including names such as build:linux, refer
+// to targets of the same namespace.
+func (p *parser) ref(name string) string {
+	if strings.HasPrefix(name, ":") {
+		return name[1:]
+	}
+	if i := strings.Index(name, ":"); i > 0 && p.namespaces[joinNamespace(p.ns, name[:i])] {
+		return joinNamespace(p.ns, name[:i]) + name[i:]
+	}
+	return p.qualify(name)
+}
+
+// addNamespace records the namespace ns of an INCLUDE ... AS or SUBDIR
+// build file, so that the files around it can refer to its targets.
+func (p *parser) addNamespace(ns string) {
+	if p.namespaces == nil {
+		p.namespaces = map[string]bool{}
+	}
+	p.namespaces[ns] = true
+}
+
+// refs returns the names of the targets referenced as names.
+func (p *parser) refs(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = p.ref(name)
+	}
+	return out
+}
+
+// joinNamespace returns the namespace inner nested in outer.
+func joinNamespace(outer, inner string) string {
+	if outer == "" {
+		return inner
+	}
+	return outer + "/" + inner
+}
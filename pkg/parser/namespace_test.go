@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	tests := []struct {
+		name  string
+		main  string
+		files map[string]string
+		want  map[string]Target
+	}{
+		{
+			name:  "same namespace",
+			main:  "INCLUDE api.phd AS api\nFROM alpine AS ci USING api:test\n",
+			files: map[string]string{"api.phd": "FROM alpine AS test USING build:linux\nFROM alpine AS build:linux\n"},
+			want: map[string]Target{
+				"api:test":        {Image: "alpine", Deps: []string{"api:build:linux"}},
+				"api:build:linux": {Image: "alpine"},
+			},
+		},
+		{
+			name:  "main project",
+			main:  "FROM alpine AS build\nINCLUDE api.phd AS api\n",
+			files: map[string]string{"api.phd": "FROM #:build AS test USING :build\n"},
+			want:  map[string]Target{"api:test": {Image: "#build", Deps: []string{"build"}}},
+		},
+		{
+			name: "nested namespace",
+			main: "INCLUDE lint.phd AS lint\n",
+			files: map[string]string{
+				"lint.phd": "INCLUDE docs.phd AS docs\nFROM alpine AS all USING docs:spelling\n",
+				"docs.phd": "FROM alpine AS spelling\n",
+			},
+			want: map[string]Target{
+				"lint:all":           {Image: "alpine", Deps: []string{"lint/docs:spelling"}},
+				"lint/docs:spelling": {Image: "alpine"},
+			},
+		},
+		{
+			name:  "target image",
+			main:  "INCLUDE api.phd AS api\n",
+			files: map[string]string{"api.phd": "FROM &build AS test\nFROM #test AS more\n"},
+			want: map[string]Target{
+				"api:test": {Image: "&build"},
+				"api:more": {Image: "#api:test"},
+			},
+		},
+		{
+			name:  "subdir base name",
+			main:  "SUBDIR services/api\nSUBDIR ../tools/Makefile.phd\n",
+			files: map[string]string{"services/api/Makefile.phd": "FROM alpine AS test\n", "../tools/Makefile.phd": "FROM alpine AS lint\n"},
+			want: map[string]Target{
+				"api":        {Deps: []string{"api:test"}},
+				"api:test":   {Image: "alpine"},
+				"tools":      {Deps: []string{"tools:lint"}},
+				"tools:lint": {Image: "alpine"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The project is a directory of the temporary directory, so that
+			// SUBDIR can refer to a sibling of it.
+			files := map[string]string{"project/Makefile.phd": tt.main}
+			for name, data := range tt.files {
+				files[filepath.ToSlash(filepath.Join("project", name))] = data
+			}
+			dir := writeProject(t, files)
+			defer os.RemoveAll(dir)
+			list, _, err := ParseFile(filepath.Join(dir, "project", "Makefile.phd"), Options{Dir: filepath.Join(dir, "project")})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, want := range tt.want {
+				got := list[name]
+				if got == nil {
+					t.Errorf("target %s was not declared", name)
+					continue
+				}
+				var deps []string
+				if len(got.Deps) > 0 {
+					deps = got.Deps
+				}
+				if got.Image != want.Image || !reflect.DeepEqual(deps, want.Deps) {
+					t.Errorf("got target %s with image %q and dependencies %q, want %q and %q", name, got.Image, deps, want.Image, want.Deps)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,695 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	reFromLine   = regexp.MustCompile(`(?i)^FROM\s+(\S+)(?:\s+AS\s+(\S+))?(?:\s+USING\s+(.+))?\s*$`)
+	reTargetLine = regexp.MustCompile(`(?i)^TARGET\s+(\S+)(?:\s+USING\s+(.+))?\s*$`)
+
+	// reMemSize matches docker memory sizes, such as 512m or 2g.
+	reMemSize = regexp.MustCompile(`(?i)^[0-9]+[bkmg]?$`)
+
+	// reTargetName matches the names of targets and dependencies that are
+	// allowed in strict mode.
+	reTargetName = regexp.MustCompile(`^[A-Za-z0-9_.%:/-]+$`)
+
+	// rePort matches docker port publishing specs, such as 8080:80 or
+	// 127.0.0.1:5432:5432/tcp.
+	rePort = regexp.MustCompile(`^(?:[0-9.]+:)?(?:[0-9]+(?:-[0-9]+)?:)?[0-9]+(?:-[0-9]+)?(?:/(?:tcp|udp|sctp))?$`)
+)
+
+// Options configure how build files are parsed.
+type Options struct {
+	// Args are the -a arguments in the form NAME=value, or NAME to take the
+	// value from the environment.
+	Args []string
+
+	// Dir is the project directory, in which the built-in variables are
+	// computed.
+	Dir string
+
+	// Strict makes unknown instructions, duplicate targets, references to
+	// targets before they are declared and malformed target names errors,
+	// as if every build file started with SYNTAX strict.
+	Strict bool
+
+	// Files are the contents of build files by absolute path, which are
+	// used instead of reading the files, such as the unsaved files of an
+	// editor.
+	Files map[string]string
+}
+
+type parser struct {
+	opts     Options
+	list     Targets
+	included map[string]bool
+	builtins map[string]string
+	errs     ErrorList
+
+	// defaultFile and defaultLine are where the default target is set
+	// with DEFAULT, if it is.
+	defaultFile string
+	defaultLine int
+
+	// lint enables the additional checks of Lint.
+	lint bool
+
+	// strict is set while parsing a build file in strict mode, which the
+	// files it includes inherit.
+	strict bool
+
+	// order counts the targets declared so far.
+	order int
+
+	// dir is the project directory of the SUBDIR build file being parsed,
+	// or empty while parsing the main project.
+	dir string
+
+	// ns is the namespace of the build file being parsed, or empty for the
+	// main project.
+	ns string
+
+	// namespaces are the namespaces of the INCLUDE ... AS and SUBDIR build
+	// files parsed so far.
+	namespaces map[string]bool
+}
+
+// ParseFile parses the targets of the build file filename and the files it
+// includes. It returns the targets and the name of the default target,
+// which is the target named by DEFAULT or else the first target of
+// filename. Problems in the build files are
+// returned together as an ErrorList.
+func ParseFile(filename string, opts Options) (Targets, string, error) {
+	p := &parser{opts: opts, list: Targets{}, included: map[string]bool{}, strict: opts.Strict}
+	defaultTarget, err := p.parse(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(p.errs) > 0 {
+		return nil, "", p.errs
+	}
+	return p.list, defaultTarget, nil
+}
+
+// errorf records an error at line num of filename.
+func (p *parser) errorf(filename string, num int, format string, args ...interface{}) {
+	p.errs = append(p.errs, &Error{File: filename, Line: num, Msg: fmt.Sprintf(format, args...)})
+}
+
+// parse parses the build file filename and the files it includes, and
+// expands their pattern and matrix targets.
+func (p *parser) parse(filename string) (defaultTarget string, err error) {
+	if defaultTarget, err = p.parseFile(filename, true); err != nil {
+		return "", err
+	}
+	p.expandPatterns()
+	p.expandMatrices()
+	p.checkArtifacts()
+	p.checkStrict()
+	if p.defaultFile != "" && p.list[defaultTarget] == nil {
+		p.errorf(p.defaultFile, p.defaultLine, "DEFAULT target %s does not exist (for an argument default, use DEFAULT %s=value)", defaultTarget, defaultTarget)
+	}
+	return defaultTarget, nil
+}
+
+// parseFile parses the targets of a build file into the list. Files that
+// were already parsed are skipped, so each file is included at most once.
+// The default target is the target named by DEFAULT, or else the first
+// target of the file; it may only be set in the main file and the main
+// files of SUBDIR projects.
+// Problems with the file's lines are recorded and parsing continues with
+// the next line; only a file that cannot be read is returned as an error.
+func (p *parser) parseFile(filename string, main bool) (defaultTarget string, err error) {
+	var atarget *Target
+	explicit := ""
+	header := true
+	defer func(strict bool) { p.strict = strict }(p.strict)
+
+	// Lines inside IF blocks are only parsed while active is set.
+	conds := []cond{}
+	active := true
+	abs, err := filepath.Abs(filename)
+	if err == nil {
+		if p.included[abs] {
+			return "", nil
+		}
+		p.included[abs] = true
+	}
+
+	data, ok := p.opts.Files[abs]
+	if !ok {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("Failed to find %s: %v", filename, err)
+		}
+		data = string(b)
+	}
+
+	for _, ln := range p.expandLoops(filename, lex(data)) {
+		errorf := func(format string, args ...interface{}) {
+			p.errorf(filename, ln.num, format, args...)
+		}
+
+		if p.conditional(&conds, &active, ln, atarget, errorf) || !active {
+			continue
+		}
+
+		// Directives may reference the environment, but Dockerfile
+		// instructions only expand -a args and built-ins so that variables
+		// like ${PATH} are left for the image build.
+		keyword := strings.ToUpper(strings.Fields(ln.text)[0])
+		line := p.expand(ln.text, atarget, directives[keyword])
+
+		c, ok := fields(line)
+		if !ok && directives[keyword] {
+			errorf("unterminated quote in %s", keyword)
+			continue
+		}
+		// A line of variables that expand to nothing has no instruction.
+		if len(c) == 0 {
+			continue
+		}
+
+		switch keyword {
+		case "FROM", "SERVICE":
+			match := reFromLine.FindStringSubmatch("FROM" + line[len(c[0]):])
+			if match == nil {
+				errorf("%s requires an image, as in %s image [AS name] [USING target...]", keyword, keyword)
+				continue
+			}
+
+			image := match[1]
+			name := match[2]
+			deps := p.refs(strings.Fields(match[3]))
+			if name == "" {
+				c := regexp.MustCompile(`\b`).Split(image, -1)
+				name = c[len(c)-1]
+			}
+			internal := strings.HasPrefix(name, "_")
+			name = p.qualify(name)
+			if strings.HasPrefix(image, "#") {
+				image = "#" + p.ref(image[1:])
+			}
+
+			if prev := p.list[name]; prev != nil && (p.lint || p.strict) {
+				errorf("duplicate target %s, first declared at %s:%d", name, prev.File, prev.Line)
+			}
+			p.checkNames(errorf, name, deps)
+			p.order++
+			header = false
+			atarget = &Target{
+				Name:      name,
+				Image:     image,
+				Deps:      deps,
+				Retries:   -1,
+				Service:   keyword == "SERVICE",
+				Internal:  internal,
+				File:      filename,
+				Line:      ln.num,
+				Dir:       p.dir,
+				Namespace: p.ns,
+				order:     p.order,
+				strict:    p.strict,
+			}
+			p.list[atarget.Name] = atarget
+			if defaultTarget == "" && !isPattern(atarget.Name) {
+				defaultTarget = atarget.Name
+			}
+			continue
+
+		case "TARGET":
+			match := reTargetLine.FindStringSubmatch(line)
+			if match == nil {
+				errorf("TARGET requires a name, as in TARGET name [USING target...]")
+				continue
+			}
+			internal := strings.HasPrefix(match[1], "_")
+			match[1] = p.qualify(match[1])
+			match[2] = strings.Join(p.refs(strings.Fields(match[2])), " ")
+			if prev := p.list[match[1]]; prev != nil && (p.lint || p.strict) {
+				errorf("duplicate target %s, first declared at %s:%d", match[1], prev.File, prev.Line)
+			}
+			p.checkNames(errorf, match[1], strings.Fields(match[2]))
+			p.order++
+			header = false
+			atarget = &Target{
+				Name:      match[1],
+				Deps:      strings.Fields(match[2]),
+				Retries:   -1,
+				Phony:     true,
+				Internal:  internal,
+				File:      filename,
+				Line:      ln.num,
+				Dir:       p.dir,
+				Namespace: p.ns,
+				order:     p.order,
+				strict:    p.strict,
+			}
+			p.list[atarget.Name] = atarget
+			if defaultTarget == "" && !isPattern(atarget.Name) {
+				defaultTarget = atarget.Name
+			}
+			continue
+
+		// Targets from included files are added to the same list, but the
+		// default target always comes from the including file.
+		case "INCLUDE":
+			if len(c) < 2 {
+				errorf("INCLUDE requires a path")
+				continue
+			}
+			header = false
+			paths, ns := c[1:], p.ns
+			if len(c) > 2 && strings.EqualFold(c[len(c)-2], "AS") {
+				if len(c) != 4 {
+					errorf("INCLUDE with a namespace takes a single path, as in INCLUDE path AS namespace")
+					continue
+				}
+				paths, ns = c[1:2], joinNamespace(p.ns, c[3])
+				p.addNamespace(ns)
+			}
+			for _, inc := range paths {
+				if !filepath.IsAbs(inc) {
+					inc = filepath.Join(filepath.Dir(filename), filepath.FromSlash(inc))
+				}
+				outer := p.ns
+				p.ns = ns
+				if _, err := p.parseFile(inc, false); err != nil {
+					errorf("%v", err)
+				}
+				p.ns = outer
+			}
+			atarget = nil
+			continue
+
+		// SUBDIR adds the targets of a nested project, which run in their
+		// own project directory.
+		case "SUBDIR":
+			if len(c) < 2 || len(c) > 3 {
+				errorf("SUBDIR requires a path and at most one target, as in SUBDIR path [target]")
+				continue
+			}
+			header = false
+			p.subdir(filename, ln.num, c[1], c[2:], errorf)
+			atarget = nil
+			continue
+
+		// SYNTAX strict turns on strict mode for the rest of the file and
+		// the files it includes.
+		case "SYNTAX":
+			switch {
+			case len(c) != 2 || !strings.EqualFold(c[1], "strict"):
+				errorf("SYNTAX requires a mode, as in SYNTAX strict")
+			case !header:
+				errorf("SYNTAX must come before the targets of %s", filename)
+			default:
+				p.strict = true
+			}
+			continue
+
+		// DEFAULT with a target name instead of NAME=value arguments picks
+		// the default target.
+		case "DEFAULT":
+			if len(c) != 2 || strings.Contains(c[1], "=") {
+				break
+			}
+			switch {
+			case !main:
+				errorf("DEFAULT target can only be set in the main build file (for an argument default, use DEFAULT %s=value)", c[1])
+			case p.defaultFile != "":
+				errorf("DEFAULT target already set at %s:%d (for an argument default, use DEFAULT %s=value)", p.defaultFile, p.defaultLine, c[1])
+			case isPattern(c[1]):
+				errorf("DEFAULT target %s cannot be a pattern target", c[1])
+			default:
+				p.defaultFile, p.defaultLine = filename, ln.num
+				explicit = p.ref(c[1])
+			}
+			continue
+		}
+
+		if atarget == nil {
+			errorf("%s outside of a target, expected FROM", c[0])
+			continue
+		}
+
+		// Targets without an image only take a description, dependencies and
+		// cleanup targets.
+		if atarget.Phony && keyword != "LABEL" && keyword != "INTERNAL" && keyword != "USING" && keyword != "ONFAILURE" && keyword != "ALWAYS" {
+			errorf("%s is not allowed in TARGET %s, which has no image", c[0], atarget.Name)
+			continue
+		}
+
+		if (p.lint || p.strict) && !directives[keyword] && !instructions[keyword] {
+			errorf("unknown instruction %s", c[0])
+		}
+
+		switch keyword {
+		case "ARTIFACT":
+			a := ParseArtifact(c[1:])
+			a.Line = ln.num
+			if a.Image && !strings.HasPrefix(a.Src, "/") {
+				errorf("ARTIFACT IMAGE source %s must be an absolute path", a.Src)
+				continue
+			}
+			if a.Src == "" {
+				errorf("ARTIFACT requires a source path")
+				continue
+			}
+			if p.lint && outsideDir(a.Dst) {
+				errorf("ARTIFACT destination %s is outside of the project directory", a.Dst)
+			}
+			atarget.Artifacts = append(atarget.Artifacts, a)
+			continue
+
+		case "SOURCES", "CACHE", "SECRET", "SSH", "MOUNT", "PASSENV", "ENVFILE", "TAG", "CACHE_FROM", "CACHE_TO", "CAP_ADD", "DEVICE", "SYNCBACK", "ONFAILURE", "ALWAYS":
+			if len(c) < 2 {
+				errorf("%s requires at least one argument", keyword)
+				continue
+			}
+			dst := map[string]*[]string{
+				"SOURCES":    &atarget.Sources,
+				"CACHE":      &atarget.Caches,
+				"SECRET":     &atarget.Secrets,
+				"SSH":        &atarget.SSH,
+				"MOUNT":      &atarget.Mounts,
+				"PASSENV":    &atarget.PassEnv,
+				"ENVFILE":    &atarget.EnvFiles,
+				"TAG":        &atarget.Tags,
+				"CACHE_FROM": &atarget.CacheFrom,
+				"CACHE_TO":   &atarget.CacheTo,
+				"CAP_ADD":    &atarget.CapAdd,
+				"DEVICE":     &atarget.Devices,
+				"SYNCBACK":   &atarget.SyncBack,
+				"ONFAILURE":  &atarget.OnFailure,
+				"ALWAYS":     &atarget.Always,
+			}[keyword]
+			if keyword == "ONFAILURE" || keyword == "ALWAYS" {
+				c = append(c[:1], p.refs(c[1:])...)
+			}
+			*dst = append(*dst, c[1:]...)
+			continue
+
+		// USING in the body adds dependencies, such as inside IF blocks.
+		case "USING":
+			if len(c) < 2 {
+				errorf("USING requires at least one target")
+				continue
+			}
+			p.checkNames(errorf, atarget.Name, p.refs(c[1:]))
+			atarget.Deps = append(atarget.Deps, p.refs(c[1:])...)
+			continue
+
+		case "INTERNAL":
+			if len(c) != 1 {
+				errorf("INTERNAL takes no arguments")
+				continue
+			}
+			atarget.Internal = true
+			continue
+
+		case "PORT":
+			if len(c) < 2 {
+				errorf("PORT requires at least one argument")
+				continue
+			}
+			for _, port := range c[1:] {
+				if !rePort.MatchString(port) {
+					errorf("invalid PORT for target %s: %s", atarget.Name, port)
+				}
+			}
+			atarget.Ports = append(atarget.Ports, c[1:]...)
+			continue
+
+		case "NETWORK":
+			if len(c) != 2 {
+				errorf("NETWORK requires none, host or a network name")
+				continue
+			}
+			atarget.Network = c[1]
+			continue
+
+		case "DOCKER_SOCKET":
+			if len(c) > 2 {
+				errorf("DOCKER_SOCKET takes at most one argument")
+				continue
+			}
+			atarget.DockerSocket = "/var/run/docker.sock"
+			if len(c) == 2 {
+				atarget.DockerSocket = c[1]
+			}
+			continue
+
+		case "USER_MAP":
+			if len(c) != 1 {
+				errorf("USER_MAP takes no arguments")
+				continue
+			}
+			atarget.UserMap = true
+			continue
+
+		case "HOOK":
+			if len(c) < 3 || (c[1] != "pre" && c[1] != "post") {
+				errorf("HOOK requires pre or post and a command, as in HOOK pre command")
+				continue
+			}
+			cmd := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[len(c[0]):]), c[1]))
+			if c[1] == "pre" {
+				atarget.PreHooks = append(atarget.PreHooks, cmd)
+			} else {
+				atarget.PostHooks = append(atarget.PostHooks, cmd)
+			}
+			continue
+
+		case "ALLOW_FAILURE":
+			if len(c) != 1 {
+				errorf("ALLOW_FAILURE takes no arguments")
+				continue
+			}
+			atarget.AllowFailure = true
+			continue
+
+		case "ISOLATE":
+			if len(c) != 1 {
+				errorf("ISOLATE takes no arguments")
+				continue
+			}
+			atarget.Isolate = true
+			continue
+
+		case "PRIVILEGED":
+			if len(c) != 1 {
+				errorf("PRIVILEGED takes no arguments")
+				continue
+			}
+			atarget.Privileged = true
+			continue
+
+		case "STEMS":
+			if len(c) < 2 {
+				errorf("STEMS requires at least one argument")
+				continue
+			}
+			atarget.stems = append(atarget.stems, c[1:]...)
+			continue
+
+		case "MATRIX":
+			if len(c) < 2 {
+				errorf("MATRIX requires NAME=value,value... arguments")
+				continue
+			}
+			axes, err := parseMatrix(c[1:])
+			if err != nil {
+				errorf("%v", err)
+				continue
+			}
+			atarget.matrix = append(atarget.matrix, axes...)
+			continue
+
+		case "REQUIRE":
+			if len(c) < 2 {
+				errorf("REQUIRE requires an argument name")
+				continue
+			}
+			desc := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[len(c[0]):]), c[1]))
+			atarget.Requires = append(atarget.Requires, Requirement{Name: c[1], Desc: strings.Trim(desc, `"`)})
+			continue
+
+		case "DEFAULT":
+			if len(c) < 2 {
+				errorf("DEFAULT requires a target name or NAME=value arguments")
+				continue
+			}
+			for _, kv := range c[1:] {
+				parts := strings.SplitN(kv, "=", 2)
+				if len(parts) != 2 || parts[0] == "" {
+					errorf("DEFAULT requires NAME=value arguments, got %s", kv)
+					continue
+				}
+				if atarget.Defaults == nil {
+					atarget.Defaults = map[string]string{}
+				}
+				atarget.Defaults[parts[0]] = strings.Trim(parts[1], `"`)
+			}
+			continue
+
+		case "PLATFORM":
+			if len(c) != 2 {
+				errorf("PLATFORM requires exactly one argument")
+				continue
+			}
+			atarget.Platform = c[1]
+			continue
+
+		case "CPUS":
+			if len(c) != 2 {
+				errorf("CPUS requires exactly one argument")
+				continue
+			}
+			if n, err := strconv.ParseFloat(c[1], 64); err != nil || n <= 0 {
+				errorf("invalid CPUS for target %s: %s", atarget.Name, c[1])
+				continue
+			}
+			atarget.CPUs = c[1]
+			continue
+
+		case "MEMORY", "SHM_SIZE":
+			if len(c) != 2 {
+				errorf("%s requires exactly one argument", keyword)
+				continue
+			}
+			if !reMemSize.MatchString(c[1]) {
+				errorf("invalid %s for target %s: %s", keyword, atarget.Name, c[1])
+				continue
+			}
+			if keyword == "MEMORY" {
+				atarget.Memory = c[1]
+			} else {
+				atarget.ShmSize = c[1]
+			}
+			continue
+
+		case "GPU":
+			if len(c) != 2 {
+				errorf("GPU requires all or comma separated device IDs")
+				continue
+			}
+			atarget.GPUs = c[1]
+			continue
+
+		case "PULL":
+			if len(c) != 2 || (strings.ToLower(c[1]) != "always" && strings.ToLower(c[1]) != "never") {
+				errorf("PULL requires always or never")
+				continue
+			}
+			atarget.Pull = strings.ToLower(c[1])
+			continue
+
+		case "TIMEOUT":
+			if len(c) != 2 {
+				errorf("TIMEOUT requires exactly one argument")
+				continue
+			}
+			if atarget.Timeout, err = time.ParseDuration(c[1]); err != nil {
+				errorf("invalid TIMEOUT for target %s: %v", atarget.Name, err)
+			}
+			continue
+
+		case "RETRY":
+			if len(c) != 2 && len(c) != 3 {
+				errorf("RETRY requires a count and an optional delay")
+				continue
+			}
+			if atarget.Retries, err = strconv.Atoi(c[1]); err != nil || atarget.Retries < 0 {
+				errorf("invalid RETRY count for target %s: %s", atarget.Name, c[1])
+			}
+			if len(c) == 3 {
+				if atarget.RetryDelay, err = time.ParseDuration(c[2]); err != nil {
+					errorf("invalid RETRY delay for target %s: %v", atarget.Name, err)
+				}
+			}
+			continue
+
+		case "ENVARG":
+			if len(c) != 2 {
+				errorf("ENVARG requires exactly one argument")
+				continue
+			}
+			atarget.Defn += line[3:] + "\n"
+			parts := strings.SplitN(c[1], "=", 2)
+			atarget.Defn += fmt.Sprintf("ENV %s=${%s}\n", parts[0], parts[0])
+			continue
+
+		// COPYFROM copies files out of another target's image. The target
+		// becomes a dependency so that its image is built first, and is
+		// referenced as #target for the runner to replace by its image.
+		case "COPYFROM":
+			if len(c) < 4 {
+				errorf("COPYFROM requires a target, a source path and a destination")
+				continue
+			}
+			c[1] = p.ref(c[1])
+			if !contains(atarget.Deps, c[1]) {
+				atarget.Deps = append(atarget.Deps, c[1])
+			}
+			line = "COPY --from=#" + c[1] + " " + strings.Join(c[2:], " ")
+
+		case "LABEL":
+			for key, value := range labels(c[1:]) {
+				switch strings.ToLower(key) {
+				case "description":
+					atarget.Desc = value
+				case "category":
+					atarget.Category = value
+				}
+			}
+		}
+
+		atarget.Defn += line + "\n"
+	}
+	for _, b := range conds {
+		p.errorf(filename, b.line, "IF without ENDIF")
+	}
+	if explicit != "" {
+		return explicit, nil
+	}
+	return defaultTarget, nil
+}
+
+// labels parses the key=value arguments of a LABEL instruction, with quotes
+// removed. Words without = are added to the previous value, and the legacy
+// form LABEL key value is also accepted.
+func labels(args []string) map[string]string {
+	m := map[string]string{}
+	if len(args) > 1 && !strings.Contains(args[0], "=") {
+		m[strings.Trim(args[0], `"`)] = strings.Trim(strings.Join(args[1:], " "), `"`)
+		return m
+	}
+	key := ""
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) == 2 {
+			key = strings.Trim(kv[0], `"`)
+			m[key] = strings.Trim(kv[1], `"`)
+		} else if key != "" {
+			m[key] += " " + strings.Trim(arg, `"`)
+		}
+	}
+	return m
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
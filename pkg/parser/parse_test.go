@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeProject writes files, by slash separated path, into a new temporary
+// project directory and returns the directory.
+func writeProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "drmake-parser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// parseProject parses the Makefile.phd of a project of files.
+func parseProject(t *testing.T, files map[string]string, opts Options) (Targets, string, error) {
+	t.Helper()
+	dir := writeProject(t, files)
+	defer os.RemoveAll(dir)
+	opts.Dir = dir
+	return ParseFile(filepath.Join(dir, "Makefile.phd"), opts)
+}
+
+// parsed is the part of a target that the parser tests compare.
+type parsed struct {
+	Deps []string
+	Defn string
+}
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		args  []string
+		want  map[string]parsed
+		def   string
+	}{
+		{
+			name:  "targets",
+			files: map[string]string{"Makefile.phd": "FROM alpine AS build\nRUN apk add make\nCMD make\n\nFROM alpine AS test USING build\nCMD make test\n"},
+			want: map[string]parsed{
+				"build": {Defn: "RUN apk add make\nCMD make\n"},
+				"test":  {Deps: []string{"build"}, Defn: "CMD make test\n"},
+			},
+			def: "build",
+		},
+		{
+			name:  "default target",
+			files: map[string]string{"Makefile.phd": "DEFAULT test\nFROM alpine AS build\nFROM alpine AS test\n"},
+			want:  map[string]parsed{"build": {}, "test": {}},
+			def:   "test",
+		},
+		{
+			name:  "argument default",
+			files: map[string]string{"Makefile.phd": "FROM alpine AS build\nDEFAULT VERSION=dev\nCMD echo ${VERSION}\n"},
+			want:  map[string]parsed{"build": {Defn: "CMD echo dev\n"}},
+			def:   "build",
+		},
+		{
+			name:  "if else",
+			files: map[string]string{"Makefile.phd": "FROM alpine AS a\nIF ${CI} == true\nCMD ci\nELSE IF ${X}\nCMD x\nELSE\nCMD local\nENDIF\n"},
+			args:  []string{"CI=false", "X=1"},
+			want:  map[string]parsed{"a": {Defn: "CMD x\n"}},
+			def:   "a",
+		},
+		{
+			name:  "if dependency",
+			files: map[string]string{"Makefile.phd": "FROM alpine AS lint\nFROM alpine AS test\nIF ${CI}\nUSING lint\nENDIF\n"},
+			args:  []string{"CI=1"},
+			want:  map[string]parsed{"lint": {}, "test": {Deps: []string{"lint"}}},
+			def:   "lint",
+		},
+		{
+			name:  "foreach",
+			files: map[string]string{"Makefile.phd": "FOREACH svc IN api web\nFROM alpine AS build-${svc}\nCMD make $svc\nENDFOR\nTARGET all USING build-api build-web\n"},
+			want: map[string]parsed{
+				"build-api": {Defn: "CMD make api\n"},
+				"build-web": {Defn: "CMD make web\n"},
+				"all":       {Deps: []string{"build-api", "build-web"}},
+			},
+			def: "build-api",
+		},
+		{
+			name:  "matrix",
+			files: map[string]string{"Makefile.phd": "FROM golang AS build\nMATRIX GOOS=linux,darwin\nCMD GOOS=$GOOS go build\n"},
+			want: map[string]parsed{
+				"build":        {Deps: []string{"build-linux", "build-darwin"}},
+				"build-linux":  {Defn: "CMD GOOS=linux go build\n"},
+				"build-darwin": {Defn: "CMD GOOS=darwin go build\n"},
+			},
+			def: "build",
+		},
+		{
+			name: "pattern targets",
+			files: map[string]string{
+				"Makefile.phd":            "FROM alpine AS %-test USING %-build\nSTEMS services/*\nCMD test %\n\nFROM alpine AS %-build\nSTEMS services/*\nCMD build %\n\nFROM alpine AS test USING %-test\n",
+				"services/api/main.go":    "package main\n",
+				"services/web/index.html": "\n",
+			},
+			want: map[string]parsed{
+				"api-build": {Defn: "CMD build api\n"},
+				"web-build": {Defn: "CMD build web\n"},
+				"api-test":  {Deps: []string{"api-build"}, Defn: "CMD test api\n"},
+				"web-test":  {Deps: []string{"web-build"}, Defn: "CMD test web\n"},
+				"test":      {Deps: []string{"api-test", "web-test"}},
+			},
+			def: "test",
+		},
+		{
+			name: "include namespace",
+			files: map[string]string{
+				"Makefile.phd": "INCLUDE lint.phd AS lint\nFROM alpine AS ci USING lint:go\n",
+				"lint.phd":     "FROM alpine AS go USING docs :ci\nFROM alpine AS docs\n",
+			},
+			want: map[string]parsed{
+				"ci":        {Deps: []string{"lint:go"}},
+				"lint:go":   {Deps: []string{"lint:docs", "ci"}},
+				"lint:docs": {},
+			},
+			def: "ci",
+		},
+		{
+			name: "subdir",
+			files: map[string]string{
+				"Makefile.phd":     "SUBDIR sub\nFROM alpine AS e2e USING sub\n",
+				"sub/Makefile.phd": "FROM alpine AS build\nFROM alpine AS test USING build\nDEFAULT test\n",
+			},
+			want: map[string]parsed{
+				"sub":       {Deps: []string{"sub:test"}},
+				"sub:build": {},
+				"sub:test":  {Deps: []string{"sub:build"}},
+				"e2e":       {Deps: []string{"sub"}},
+			},
+			def: "e2e",
+		},
+		{
+			name:  "whitespace lines",
+			files: map[string]string{"Makefile.phd": "\f\nFROM alpine AS a\n\v\nFOREACH x IN b\n\f\nRUN echo $x\nENDFOR\nCMD true\n"},
+			want:  map[string]parsed{"a": {Defn: "RUN echo b\nCMD true\n"}},
+			def:   "a",
+		},
+		{
+			name:  "empty expansion",
+			files: map[string]string{"Makefile.phd": "${X}\nFROM alpine AS a\n${X}\nCMD true\n"},
+			args:  []string{"X="},
+			want:  map[string]parsed{"a": {Defn: "CMD true\n"}},
+			def:   "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, def, err := parseProject(t, tt.files, Options{Args: tt.args})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := map[string]parsed{}
+			for name, s := range list {
+				var deps []string
+				if len(s.Deps) > 0 {
+					deps = s.Deps
+				}
+				got[name] = parsed{Deps: deps, Defn: s.Defn}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got targets %q, want %q", got, tt.want)
+			}
+			if def != tt.def {
+				t.Errorf("got default target %q, want %q", def, tt.def)
+			}
+		})
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts Options
+		want string
+	}{
+		{"instruction outside target", "CMD true\n", Options{}, "CMD outside of a target, expected FROM"},
+		{"missing default target", "FROM alpine AS a\nDEFAULT VERSION\n", Options{}, "DEFAULT target VERSION does not exist (for an argument default, use DEFAULT VERSION=value)"},
+		{"default target set twice", "DEFAULT a\nFROM alpine AS a\nDEFAULT VERSION\n", Options{}, "DEFAULT target already set at"},
+		{"unknown instruction", "SYNTAX strict\nFROM alpine AS a\nARTFACT a b\n", Options{}, "unknown instruction ARTFACT"},
+		{"strict empty expansion", "FROM alpine AS a\n${X}\nARTFACT a b\n", Options{Args: []string{"X="}, Strict: true}, "unknown instruction ARTFACT"},
+		{"unterminated if", "FROM alpine AS a\nIF ${CI}\nCMD true\n", Options{}, "IF without ENDIF"},
+		{"endif without if", "FROM alpine AS a\nENDIF\n", Options{}, "ENDIF without IF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseProject(t, map[string]string{"Makefile.phd": tt.src}, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArtifactPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		args []string
+		want string
+		err  string
+	}{
+		{"plain", "ARTIFACT build/app dist/app", nil, "dist/app", ""},
+		{"variable", "ARTIFACT build/app dist/${VERSION}/app", []string{"VERSION=1.0"}, "dist/1.0/app", ""},
+		{"undefined variable", "ARTIFACT build/app dist/${VERSION}/app", nil, "", "undefined variable VERSION in ARTIFACT destination dist/${VERSION}/app"},
+		{"escaped dollar", "ARTIFACT build/app dist/$$VERSION/app", []string{"VERSION=1.0"}, "dist/$VERSION/app", ""},
+		{"escaped and undefined", "ARTIFACT build/app dist/$$HOME/${VERSION}", nil, "", "undefined variable VERSION"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "FROM alpine AS a\n" + tt.line + "\n"
+			list, _, err := parseProject(t, map[string]string{"Makefile.phd": src}, Options{Args: tt.args})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := list["a"].Artifacts[0].Dst; got != tt.want {
+				t.Errorf("got destination %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"canonical", "FROM alpine AS a\nCMD true\n", "FROM alpine AS a\nCMD true\n"},
+		{"keywords", "from alpine as a\ncmd   true  \n", "FROM alpine AS a\nCMD true\n"},
+		{"line endings", "FROM alpine AS a\r\nCMD true\r\n", "FROM alpine AS a\nCMD true\n"},
+		{"continuations", "FROM alpine AS a\nRUN make \\\n  all\n", "FROM alpine AS a\nRUN make \\\n    all\n"},
+		{"artifacts", "FROM alpine AS a\nARTIFACT b dst/b\nARTIFACT a dst/a\n", "FROM alpine AS a\nARTIFACT a dst/a\nARTIFACT b dst/b\n"},
+		{"blank lines", "FROM alpine AS a\n\n\n# build it\nCMD make\nFROM alpine AS b\n", "FROM alpine AS a\n\n# build it\nCMD make\n\nFROM alpine AS b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(tt.src); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
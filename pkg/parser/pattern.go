@@ -0,0 +1,159 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isPattern returns whether name is the name of a pattern target, such as
+// %-test, which is expanded into a target for each of its stems.
+func isPattern(name string) bool {
+	return strings.Contains(strings.Replace(name, "%%", "", -1), "%")
+}
+
+// expandPatterns replaces the pattern targets in the list by a target for
+// each of their stems, with % replaced by the stem. Targets declared by
+// name take precedence over expanded targets of the same name. Afterwards,
+// dependencies on a pattern (USING %-test) in other targets are replaced
+// by all targets expanded from it.
+func (p *parser) expandPatterns() {
+	expanded := map[string][]string{}
+	for _, pt := range p.list.Sorted() {
+		if !isPattern(pt.Name) {
+			if len(pt.stems) > 0 {
+				p.errorf(pt.File, pt.Line, "STEMS is only allowed in pattern targets, such as %%-%s", pt.Name)
+			}
+			continue
+		}
+		delete(p.list, pt.Name)
+		stems := p.stems(pt)
+		if len(stems) == 0 {
+			p.errorf(pt.File, pt.Line, "pattern target %s has no stems, add them with STEMS", pt.Name)
+			continue
+		}
+		for _, stem := range stems {
+			t := pt.withStem(stem)
+			expanded[pt.Name] = append(expanded[pt.Name], t.Name)
+			if p.list[t.Name] == nil {
+				p.list[t.Name] = t
+			}
+		}
+	}
+
+	for _, t := range p.list.Sorted() {
+		deps := []string{}
+		for _, dep := range t.Deps {
+			if !isPattern(dep) {
+				deps = append(deps, dep)
+				continue
+			}
+			if _, ok := expanded[dep]; !ok {
+				p.errorf(t.File, t.Line, "target %s refers to unknown pattern target %s", t.Name, dep)
+			}
+			deps = append(deps, expanded[dep]...)
+		}
+		t.Deps = deps
+	}
+}
+
+// stems returns the stems of a pattern target. STEMS arguments containing
+// glob characters match directories in the project directory and
+// contribute their base names, other arguments are stems themselves.
+func (p *parser) stems(pt *Target) []string {
+	stems := []string{}
+	seen := map[string]bool{}
+	add := func(stem string) {
+		if !seen[stem] {
+			seen[stem] = true
+			stems = append(stems, stem)
+		}
+	}
+	for _, arg := range pt.stems {
+		if !strings.ContainsAny(arg, "*?[") {
+			add(arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p.opts.Dir, filepath.FromSlash(arg)))
+		if err != nil {
+			p.errorf(pt.File, pt.Line, "invalid STEMS pattern %s: %v", arg, err)
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				add(filepath.Base(match))
+			}
+		}
+	}
+	return stems
+}
+
+// withStem returns a copy of the pattern target with % replaced by stem
+// everywhere. %% stands for a literal %.
+func (pt *Target) withStem(stem string) *Target {
+	t := pt.substituted(func(s string) string {
+		parts := strings.Split(s, "%%")
+		for i, part := range parts {
+			parts[i] = strings.Replace(part, "%", stem, -1)
+		}
+		return strings.Join(parts, "%")
+	})
+	t.Pattern = pt.Name
+	t.stems = nil
+	return t
+}
+
+// substituted returns a copy of the target with sub applied to its name,
+// image, dependencies, Dockerfile lines and the arguments of its
+// directives.
+func (pt *Target) substituted(sub func(string) string) *Target {
+	subs := func(list []string) []string {
+		if list == nil {
+			return nil
+		}
+		out := make([]string, len(list))
+		for i, s := range list {
+			out[i] = sub(s)
+		}
+		return out
+	}
+
+	t := *pt
+	t.Name = sub(pt.Name)
+	t.Image = sub(pt.Image)
+	t.Defn = sub(pt.Defn)
+	t.Desc = sub(pt.Desc)
+	t.Deps = subs(pt.Deps)
+	t.Sources = subs(pt.Sources)
+	t.Caches = subs(pt.Caches)
+	t.Secrets = subs(pt.Secrets)
+	t.SSH = subs(pt.SSH)
+	t.Mounts = subs(pt.Mounts)
+	t.Tags = subs(pt.Tags)
+	t.PassEnv = subs(pt.PassEnv)
+	t.EnvFiles = subs(pt.EnvFiles)
+	t.CacheFrom = subs(pt.CacheFrom)
+	t.CacheTo = subs(pt.CacheTo)
+	t.GPUs = sub(pt.GPUs)
+	t.CapAdd = subs(pt.CapAdd)
+	t.Devices = subs(pt.Devices)
+	t.DockerSocket = sub(pt.DockerSocket)
+	t.Network = sub(pt.Network)
+	t.Ports = subs(pt.Ports)
+	t.SyncBack = subs(pt.SyncBack)
+	t.OnFailure = subs(pt.OnFailure)
+	t.Always = subs(pt.Always)
+	t.PreHooks = subs(pt.PreHooks)
+	t.PostHooks = subs(pt.PostHooks)
+	if pt.Defaults != nil {
+		t.Defaults = map[string]string{}
+		for name, value := range pt.Defaults {
+			t.Defaults[name] = sub(value)
+		}
+	}
+	t.Artifacts = make([]Artifact, len(pt.Artifacts))
+	for i, a := range pt.Artifacts {
+		t.Artifacts[i] = Artifact{Src: sub(a.Src), Dst: sub(a.Dst), Exclude: subs(a.Exclude), Image: a.Image, Line: a.Line}
+	}
+	return &t
+}
@@ -0,0 +1,55 @@
+package parser
+
+import "strings"
+
+// checkNames reports malformed names in the declaration of the target name
+// with dependencies deps in strict mode, such as a stray AS in the USING
+// list.
+func (p *parser) checkNames(errorf func(format string, args ...interface{}), name string, deps []string) {
+	if !p.strict {
+		return
+	}
+	if !reTargetName.MatchString(name) {
+		errorf("invalid target name %s", name)
+	}
+	for _, dep := range deps {
+		if strings.EqualFold(dep, "AS") || strings.EqualFold(dep, "USING") {
+			errorf("unexpected %s in the dependencies of target %s", dep, name)
+		} else if !reTargetName.MatchString(dep) {
+			errorf("invalid dependency %s of target %s", dep, name)
+		}
+	}
+}
+
+// checkStrict reports the dependencies and FROM #target images of targets
+// declared in strict mode that refer to targets which are unknown or
+// declared after them, and ONFAILURE and ALWAYS targets that are unknown.
+func (p *parser) checkStrict() {
+	for _, t := range p.list.Sorted() {
+		if !t.strict {
+			continue
+		}
+		refs := t.Deps
+		if strings.HasPrefix(t.Image, "#") && t.Image[1:] != t.Name {
+			refs = append([]string{t.Image[1:]}, refs...)
+		}
+		for _, name := range refs {
+			ref := p.list[name]
+			switch {
+			case strings.EqualFold(name, "AS") || strings.EqualFold(name, "USING"):
+				// Already reported by checkNames.
+			case ref == nil && !p.lint:
+				p.errorf(t.File, t.Line, "target %s refers to unknown target %s", t.Name, name)
+			case ref != nil && ref.order > t.order:
+				p.errorf(t.File, t.Line, "target %s refers to target %s before it is declared at %s:%d", t.Name, name, ref.File, ref.Line)
+			}
+		}
+		// Cleanup targets are usually declared after the targets they clean
+		// up after, so only their existence is checked.
+		for _, name := range append(append([]string{}, t.OnFailure...), t.Always...) {
+			if p.list[name] == nil && !p.lint {
+				p.errorf(t.File, t.Line, "target %s refers to unknown target %s", t.Name, name)
+			}
+		}
+	}
+}
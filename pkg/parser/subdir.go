@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// subdirFile is the build file of a SUBDIR project that names a directory.
+const subdirFile = "Makefile.phd"
+
+// subdir parses the build file of the SUBDIR project at dir, resolved
+// relative to filename, whose targets run in the project's own directory
+// and are declared in a namespace named after the project directory's base
+// name. It adds a TARGET named after the namespace that runs target, or the
+// default target of the project if target is empty.
+func (p *parser) subdir(filename string, num int, dir string, target []string, errorf func(string, ...interface{})) {
+	file := filepath.FromSlash(dir)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(filename), file)
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		file = filepath.Join(file, subdirFile)
+	}
+	abs, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		errorf("%v", err)
+		return
+	}
+
+	// The project has its own default target, directory and namespace.
+	outer, outerNS, defaultFile, defaultLine := p.dir, p.ns, p.defaultFile, p.defaultLine
+	ns := joinNamespace(p.ns, filepath.Base(abs))
+	p.addNamespace(ns)
+	p.dir, p.ns, p.defaultFile, p.defaultLine = abs, ns, "", 0
+	defaultTarget, err := p.parseFile(file, true)
+	if len(target) > 0 {
+		defaultTarget = p.ref(target[0])
+	}
+	p.dir, p.ns, p.defaultFile, p.defaultLine = outer, outerNS, defaultFile, defaultLine
+	if err != nil {
+		errorf("%v", err)
+		return
+	}
+	if defaultTarget == "" {
+		errorf("SUBDIR %s has no default target, name one as in SUBDIR %s target", dir, dir)
+		return
+	}
+
+	if prev := p.list[ns]; prev != nil && (p.lint || p.strict) {
+		errorf("duplicate target %s, first declared at %s:%d", ns, prev.File, prev.Line)
+	}
+	p.order++
+	p.list[ns] = &Target{
+		Name:      ns,
+		Deps:      []string{defaultTarget},
+		Retries:   -1,
+		Phony:     true,
+		File:      filename,
+		Line:      num,
+		Dir:       outer,
+		Namespace: outerNS,
+		order:     p.order,
+		strict:    p.strict,
+	}
+}
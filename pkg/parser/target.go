@@ -0,0 +1,265 @@
+// Package parser parses drmake build files (Makefile.phd) into targets.
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Target is a target declared in a build file with FROM, SERVICE or TARGET.
+type Target struct {
+	Name  string
+	Image string
+	Defn  string
+	Desc  string
+	Deps  []string
+
+	// Category is the LABEL category of the target, which listings group
+	// targets by.
+	Category string
+
+	Sources  []string
+	Caches   []string
+	Secrets  []string
+	SSH      []string
+	Mounts   []string
+	Tags     []string
+	PassEnv  []string
+	EnvFiles []string
+	Requires []Requirement
+
+	// CacheFrom and CacheTo are the CACHE_FROM and CACHE_TO build caches.
+	CacheFrom []string
+	CacheTo   []string
+
+	// Defaults are the DEFAULT values of -a arguments, used by the rest of
+	// the target's lines and passed as build args unless overridden.
+	Defaults map[string]string
+
+	Platform string
+	Timeout  time.Duration
+
+	// CPUs, Memory and ShmSize are the CPUS, MEMORY and SHM_SIZE limits of
+	// the target's container.
+	CPUs    string
+	Memory  string
+	ShmSize string
+
+	// GPUs are the GPU devices given to the target's container: "all" or
+	// comma separated device IDs.
+	GPUs string
+
+	// Privileged, CapAdd and Devices are the extra runtime privileges of the
+	// target's container, granted by PRIVILEGED, CAP_ADD and DEVICE.
+	Privileged bool
+	CapAdd     []string
+	Devices    []string
+
+	// Ports are the PORT specs of the target's container, published on the
+	// daemon's host.
+	Ports []string
+
+	// Network is the NETWORK of the target's container: none, host or the
+	// name of a network.
+	Network string
+
+	// UserMap is set for targets marked USER_MAP, whose containers run as
+	// the invoking user in host mode.
+	UserMap bool
+
+	// DockerSocket is the daemon socket mounted into the target's container
+	// by DOCKER_SOCKET, so that it can run docker itself.
+	DockerSocket string
+
+	// Pull is the PULL policy of the target, "always" or "never", or empty
+	// to follow the command line.
+	Pull string
+
+	// Retries is the RETRY count, or -1 if the target has none.
+	Retries    int
+	RetryDelay time.Duration
+
+	Artifacts []Artifact
+
+	// SyncBack are the SYNCBACK paths of the workspace volume copied back to
+	// the project directory after the target runs.
+	SyncBack []string
+
+	// PreHooks and PostHooks are the shell commands of HOOK pre and HOOK
+	// post, run on the host before and after the target.
+	PreHooks  []string
+	PostHooks []string
+
+	// OnFailure and Always are the ONFAILURE and ALWAYS targets, which run
+	// at the end of every run that includes the target, if the run failed
+	// or in any case.
+	OnFailure []string
+	Always    []string
+
+	// AllowFailure is set for targets marked ALLOW_FAILURE, which may fail
+	// without failing the run.
+	AllowFailure bool
+
+	// Isolate is set for targets marked ISOLATE, which run in their own
+	// copy of the workspace volume.
+	Isolate bool
+
+	// Service is set for targets declared with SERVICE, whose containers
+	// run detached while the targets that depend on them run.
+	Service bool
+
+	// Phony is set for targets declared with TARGET, which have no image
+	// and only group their dependencies.
+	Phony bool
+
+	// Internal is set for targets marked INTERNAL or whose names start with
+	// an underscore, which are meant to be used by other targets only.
+	Internal bool
+
+	// File and Line are where the target is declared.
+	File string
+	Line int
+
+	// Dir is the absolute project directory of targets declared in SUBDIR
+	// build files, which run with their own workspace and cache volumes, or
+	// empty for targets of the main project.
+	Dir string
+
+	// Namespace is the namespace of targets declared in SUBDIR build files
+	// and files included with INCLUDE path AS namespace, which their names
+	// are prefixed with as in namespace:name. It is empty for targets of the
+	// main project.
+	Namespace string
+
+	// Pattern is the name of the pattern target (such as %-test) that the
+	// target was expanded from, if any.
+	Pattern string
+
+	// order is the position of the target among the declared targets, and
+	// strict is set if it was declared in strict mode.
+	order  int
+	strict bool
+
+	// stems are the STEMS arguments of a pattern target.
+	stems []string
+
+	// matrix are the MATRIX arguments of the target.
+	matrix []axis
+}
+
+// Targets maps target names to targets.
+type Targets map[string]*Target
+
+// Find returns the target named name.
+func (s Targets) Find(name string) (*Target, error) {
+	if s[name] == nil {
+		return nil, fmt.Errorf("Unknown target: %s", name)
+	}
+	return s[name], nil
+}
+
+// Sorted returns the targets ordered by where they are declared.
+func (s Targets) Sorted() []*Target {
+	list := make([]*Target, 0, len(s))
+	for _, t := range s {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].File != list[j].File {
+			return list[i].File < list[j].File
+		}
+		return list[i].Line < list[j].Line
+	})
+	return list
+}
+
+func (s *Target) String() string {
+	return fmt.Sprintf("target %s FROM %s: %s\n%s",
+		s.Name, s.Image, strings.Join(s.Deps, " "), s.Defn)
+}
+
+// Requirement is an -a argument that a target declares with REQUIRE.
+type Requirement struct {
+	Name string
+	Desc string
+}
+
+func (r Requirement) String() string {
+	if r.Desc == "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s (%s)", r.Name, r.Desc)
+}
+
+// Dockerfile returns the Dockerfile of the target. Images referring to
+// directories (FROM ./path and FROM &target) are resolved from dir. An empty
+// Dockerfile is returned for targets that reuse their own image with
+// FROM #name and for TARGET targets.
+//
+// COPYFROM lines are generated as COPY --from=#target instructions, in which
+// #target has to be replaced by the image of the target before building.
+func (s *Target) Dockerfile(list Targets, dir string) (string, error) {
+	return s.dockerfile(list, dir, nil)
+}
+
+// dockerfile is Dockerfile for a target that the targets in chain build on
+// with FROM #target.
+func (s *Target) dockerfile(list Targets, dir string, chain []string) (string, error) {
+	for i, name := range chain {
+		if name == s.Name {
+			return "", fmt.Errorf("dependency cycle: %s", strings.Join(append(append([]string{}, chain[i:]...), s.Name), " -> "))
+		}
+	}
+	if s.Phony {
+		return "", nil
+	}
+
+	var err error
+	preface := "FROM " + s.Image
+	if path := s.ImageDir(dir); path != "" {
+		preface, err = s.dockerfileFromPath(path)
+	} else if strings.HasPrefix(s.Image, "#") {
+		if s.Image[1:] == s.Name {
+			return "", nil
+		}
+		var pretarget *Target
+		if pretarget, err = list.Find(s.Image[1:]); err == nil && pretarget.Phony {
+			err = fmt.Errorf("target %s has no image to build on", pretarget.Name)
+		} else if err == nil {
+			preface, err = pretarget.dockerfile(list, dir, append(chain[:len(chain):len(chain)], s.Name))
+			preface = strings.Trim(preface, " \r\n")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{preface, s.Defn}, "\n"), nil
+}
+
+// ImageDir returns the directory holding the Dockerfile of a FROM ./path or
+// FROM &target image, resolved from dir (or the target's SUBDIR project),
+// or an empty string for other images.
+func (s *Target) ImageDir(dir string) string {
+	if s.Dir != "" {
+		dir = s.Dir
+	}
+	switch {
+	case strings.HasPrefix(s.Image, "&"):
+		return filepath.Join(dir, ".drmake", "targets", s.Image[1:])
+	case strings.HasPrefix(s.Image, "./"):
+		return filepath.Join(dir, s.Image[2:])
+	}
+	return ""
+}
+
+func (s *Target) dockerfileFromPath(path string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, "Dockerfile"))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read image: %s: %v", s.Image, err)
+	}
+	return strings.Trim(string(data), " \r\n"), nil
+}
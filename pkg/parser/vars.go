@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// reVariable matches variable references, as well as $$, a literal
+	// dollar sign that is never expanded and has no name.
+	reVariable = regexp.MustCompile(`\$\$|\$\{(\w+)\}|\$(\w+)`)
+
+	// directives are the build file keywords handled by drmake itself rather
+	// than passed through to the generated Dockerfile.
+	directives = map[string]bool{
+		"FROM":          true,
+		"INCLUDE":       true,
+		"ARTIFACT":      true,
+		"SOURCES":       true,
+		"CACHE":         true,
+		"SECRET":        true,
+		"SSH":           true,
+		"MOUNT":         true,
+		"TAG":           true,
+		"PLATFORM":      true,
+		"TIMEOUT":       true,
+		"RETRY":         true,
+		"SERVICE":       true,
+		"TARGET":        true,
+		"PASSENV":       true,
+		"ENVFILE":       true,
+		"REQUIRE":       true,
+		"DEFAULT":       true,
+		"STEMS":         true,
+		"MATRIX":        true,
+		"INTERNAL":      true,
+		"CACHE_FROM":    true,
+		"CACHE_TO":      true,
+		"PULL":          true,
+		"CPUS":          true,
+		"MEMORY":        true,
+		"SHM_SIZE":      true,
+		"GPU":           true,
+		"PRIVILEGED":    true,
+		"CAP_ADD":       true,
+		"DEVICE":        true,
+		"DOCKER_SOCKET": true,
+		"NETWORK":       true,
+		"PORT":          true,
+		"USER_MAP":      true,
+		"SYNCBACK":      true,
+		"ISOLATE":       true,
+		"HOOK":          true,
+		"SYNTAX":        true,
+		"USING":         true,
+		"IF":            true,
+		"ELSE":          true,
+		"ENDIF":         true,
+		"FOREACH":       true,
+		"ENDFOR":        true,
+		"ONFAILURE":     true,
+		"ALWAYS":        true,
+		"ALLOW_FAILURE": true,
+		"SUBDIR":        true,
+	}
+)
+
+// HasArg returns whether args (in the form NAME=value or NAME) contain an
+// argument named name.
+func HasArg(args []string, name string) bool {
+	for _, arg := range args {
+		if strings.SplitN(arg, "=", 2)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinVars returns the built-in variables available to build files.
+func (p *parser) builtinVars() map[string]string {
+	if p.builtins == nil {
+		git := func(args ...string) string {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = p.opts.Dir
+			out, _ := cmd.Output()
+			return strings.TrimSpace(string(out))
+		}
+		p.builtins = map[string]string{
+			"GIT_SHA":    git("rev-parse", "HEAD"),
+			"GIT_BRANCH": git("rev-parse", "--abbrev-ref", "HEAD"),
+			"DATE":       time.Now().UTC().Format("2006-01-02"),
+		}
+	}
+	return p.builtins
+}
+
+// lookupVar returns the value of a variable from -a args, the DEFAULT
+// values of target t, built-ins and, if env is set, the environment (in that
+// order of precedence). The MATRIX arguments of t are not looked up, as they
+// are substituted when the target is expanded. t may be nil.
+func (p *parser) lookupVar(name string, t *Target, env bool) (string, bool) {
+	var defaults map[string]string
+	if t != nil {
+		defaults = t.Defaults
+		for _, a := range t.matrix {
+			if a.name == name {
+				return "", false
+			}
+		}
+	}
+	for _, arg := range p.opts.Args {
+		kv := strings.SplitN(arg, "=", 2)
+		if kv[0] != name {
+			continue
+		}
+		if len(kv) == 2 {
+			return kv[1], true
+		}
+		return os.LookupEnv(name)
+	}
+	if value, ok := defaults[name]; ok {
+		return value, true
+	}
+	if value, ok := p.builtinVars()[name]; ok {
+		return value, true
+	}
+	if env {
+		return os.LookupEnv(name)
+	}
+	return "", false
+}
+
+// expand replaces $VAR and ${VAR} references in s. Unknown variables are
+// left untouched so that they can still be expanded by docker or the shell
+// inside the container.
+func (p *parser) expand(s string, t *Target, env bool) string {
+	return reVariable.ReplaceAllStringFunc(s, func(ref string) string {
+		m := reVariable.FindStringSubmatch(ref)
+		name := m[1] + m[2]
+		if value, ok := p.lookupVar(name, t, env); ok {
+			return value
+		}
+		return ref
+	})
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// cacheFrom returns the --cache-from specs of the target built for
+// platform.
+func (r *Runner) cacheFrom(s *parser.Target, platform string) []string {
+	return r.cacheSpecs(r.CacheFrom, s.CacheFrom, s, platform, "")
+}
+
+// cacheTo returns the --cache-to specs of the target built for platform.
+// Registry caches are exported with all layers (mode=max) so that the
+// layers of intermediate build stages are reused as well.
+func (r *Runner) cacheTo(s *parser.Target, platform string) []string {
+	return r.cacheSpecs(r.CacheTo, s.CacheTo, s, platform, ",mode=max")
+}
+
+// cacheSpecs turns the global and per-target cache settings into cache
+// specs. Global settings naming a repository are tagged with the target
+// and platform so that targets do not overwrite each other's cache; the
+// settings of a target are used as given. Settings without an = are
+// registry references, anything else is passed through as a full spec.
+func (r *Runner) cacheSpecs(global, target []string, s *parser.Target, platform, extra string) []string {
+	specs := []string{}
+	for _, ref := range global {
+		if !strings.Contains(ref, "=") {
+			tag := s.Name
+			if platform != "" {
+				tag += "-" + strings.Replace(platform, "/", "-", -1)
+			}
+			ref = strings.TrimSuffix(ref, "/") + ":" + tag
+		}
+		specs = append(specs, cacheSpec(ref, extra))
+	}
+	for _, ref := range target {
+		specs = append(specs, cacheSpec(ref, extra))
+	}
+	return specs
+}
+
+// cacheSpec returns the cache spec for ref, which is a registry reference
+// unless it contains an =.
+func cacheSpec(ref, extra string) string {
+	if strings.Contains(ref, "=") {
+		return ref
+	}
+	return "type=registry,ref=" + ref + extra
+}
@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"log"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/graph"
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// runCleanup runs the ONFAILURE targets of the targets of a run if the run
+// failed with err, and then their ALWAYS targets, along with the
+// dependencies of those that did not succeed in the run. Their results are added to
+// results. A failing cleanup target fails a run that succeeded. Nothing is
+// run after an interruption.
+func (r *Runner) runCleanup(list parser.Targets, runTargets []*parser.Target, results *[]*Result, prepare func(*parser.Target), err error) error {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(cleanup []string) {
+		for _, name := range cleanup {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if err != nil {
+		for _, t := range runTargets {
+			add(t.OnFailure)
+		}
+	}
+	for _, t := range runTargets {
+		add(t.Always)
+	}
+	if len(names) == 0 || r.Interrupted() {
+		return err
+	}
+	order, oerr := graph.ExecOrder(list, names)
+	if oerr != nil {
+		log.Print(oerr)
+		if err == nil {
+			err = oerr
+		}
+		return err
+	}
+
+	succeeded := map[string]bool{}
+	for _, res := range *results {
+		if res.Status == "ok" {
+			succeeded[res.Target] = true
+		}
+	}
+	var errs MultiError
+	failed := map[string]bool{}
+	for _, target := range order {
+		if succeeded[target.Name] || r.Interrupted() {
+			continue
+		}
+		res := &Result{Target: target.Name}
+		*results = append(*results, res)
+		if dep := failedDep(target, failed); dep != "" {
+			log.Printf("Skipping cleanup target %s because %s failed\n", target.Name, dep)
+			failed[target.Name] = true
+			res.Status = "skipped"
+			continue
+		}
+		log.Printf("Running cleanup target %s\n", target.Name)
+		if !target.Phony {
+			prepare(target)
+		}
+		start := time.Now()
+		r.emit(Event{Type: "target_started", Target: target.Name})
+		cerr := r.runTargetHooked(list, target, res)
+		res.AllowedFailure = cerr != nil && target.AllowFailure
+		r.emitFinished(res, cerr)
+		if res.AllowedFailure {
+			log.Printf("Cleanup target %s failed, which it is allowed to: %v\n", target.Name, cerr)
+			res.Status, res.Error = "failed", cerr.Error()
+			continue
+		}
+		if cerr != nil {
+			log.Printf("Cleanup target %s failed after %s: %v\n", target.Name, time.Since(start).Round(time.Millisecond), cerr)
+			res.Status, res.Error = "failed", cerr.Error()
+			failed[target.Name] = true
+			errs = append(errs, cerr)
+			continue
+		}
+		if res.Status == "" {
+			res.Status = "ok"
+		}
+	}
+	if err == nil && len(errs) > 0 {
+		if len(errs) == 1 {
+			return errs[0]
+		}
+		return errs
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+var reCopyFrom = regexp.MustCompile(`(?im)^(COPY\s+--from=)#(\S+)`)
+
+// Dockerfile returns the Dockerfile that the target's image is built from,
+// with COPY --from=#target references replaced by the images of the targets
+// and base images pinned by the lock file.
+func (r *Runner) Dockerfile(list parser.Targets, s *parser.Target) (string, error) {
+	dfile, err := s.Dockerfile(list, r.Dir)
+	if err != nil {
+		return "", err
+	}
+	return r.pinImages(r.resolveCopyFrom(list, dfile, r.platforms(s)[0])), nil
+}
+
+// resolveCopyFrom replaces COPY --from=#target references in dfile by the
+// images of the targets for platform, or for their own first platform if
+// they are not built for platform.
+func (r *Runner) resolveCopyFrom(list parser.Targets, dfile, platform string) string {
+	return reCopyFrom.ReplaceAllStringFunc(dfile, func(ref string) string {
+		m := reCopyFrom.FindStringSubmatch(ref)
+		return m[1] + r.copyFromImage(list, m[2], platform)
+	})
+}
+
+// copyFromImage returns the image of the target named name that a target
+// built for platform copies files from.
+func (r *Runner) copyFromImage(list parser.Targets, name, platform string) string {
+	t := list[name]
+	if t == nil {
+		return r.imageOf(name)
+	}
+	platforms := r.platforms(t)
+	for _, p := range platforms {
+		if p == platform {
+			return r.platformTag(t, p)
+		}
+	}
+	return r.platformTag(t, platforms[0])
+}
+
+// copyFromImageIDs returns the IDs of the images that dfile copies files
+// from, so that rebuilding them changes the digest of the target.
+func (r *Runner) copyFromImageIDs(list parser.Targets, s *parser.Target, dfile string) []string {
+	ids := []string{}
+	for _, m := range reCopyFrom.FindAllStringSubmatch(dfile, -1) {
+		out, _ := r.rt.Command("image", "inspect", "-f", "{{.Id}}", r.copyFromImage(list, m[2], r.platforms(s)[0])).Output()
+		ids = append(ids, strings.TrimSpace(string(out)))
+	}
+	return ids
+}
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// digest returns a content hash of everything that affects a target's
+// result: the generated Dockerfile, the build args and the contents of
+// every path declared with SOURCES, as well as the images it copies files
+// from with COPYFROM and the environment and mounts of its container.
+func (r *Runner) digest(list parser.Targets, s *parser.Target, dfile string) string {
+	h := sha1.New()
+	io.WriteString(h, r.pinImages(dfile))
+	for _, id := range r.copyFromImageIDs(list, s, dfile) {
+		io.WriteString(h, "\x00image:"+id)
+	}
+	for _, platform := range r.platforms(s) {
+		io.WriteString(h, "\x00platform:"+platform)
+	}
+	for _, arg := range r.Args {
+		io.WriteString(h, "\x00arg:"+arg)
+	}
+	for _, arg := range r.commandArgs(s) {
+		io.WriteString(h, "\x00cmd:"+arg)
+	}
+	r.digestEnv(h, s)
+	for _, mount := range s.Mounts {
+		io.WriteString(h, "\x00mount:"+r.hostMount(mount))
+	}
+
+	for _, name := range r.sourceFiles(s) {
+		rel, _ := filepath.Rel(r.Dir, name)
+		io.WriteString(h, "\x00file:"+filepath.ToSlash(rel)+"\x00")
+		if f, err := os.Open(name); err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// digestEnv writes the environment of the target's container to h: the
+// contents of its env files and the values of the variables forwarded from
+// the host with PASSENV and --env.
+func (r *Runner) digestEnv(h io.Writer, s *parser.Target) {
+	for _, file := range append(append([]string{}, r.EnvFile...), s.EnvFiles...) {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(r.Dir, filepath.FromSlash(file))
+		}
+		io.WriteString(h, "\x00envfile:")
+		if f, err := os.Open(file); err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+	}
+	for _, env := range append(append([]string{}, s.PassEnv...), r.Env...) {
+		if !strings.Contains(env, "=") {
+			if value, ok := os.LookupEnv(env); ok {
+				env += "=" + value
+			}
+		}
+		io.WriteString(h, "\x00env:"+env)
+	}
+}
+
+// digestTag returns the tag that marks the target's image built for
+// platform as up to date with digest.
+func (r *Runner) digestTag(s *parser.Target, digest, platform string) string {
+	if platform == "" {
+		return r.Tag(s) + ":" + digest
+	}
+	return r.Tag(s) + ":" + digest + "-" + strings.Replace(platform, "/", "-", -1)
+}
+
+// digestTagged returns whether the target's images for all platforms are
+// tagged with digest.
+func (r *Runner) digestTagged(s *parser.Target, digest string, platforms []string) bool {
+	for _, platform := range platforms {
+		if !r.imageExists(r.digestTag(s, digest, platform)) {
+			return false
+		}
+	}
+	return true
+}
+
+// imageExists returns whether the runtime has an image tagged tag.
+func (r *Runner) imageExists(tag string) bool {
+	return r.rt.Command("image", "inspect", tag).Run() == nil
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+func TestDigestRunInputs(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *Runner, s *parser.Target)
+	}{
+		{"passenv value", func(r *Runner, s *parser.Target) { os.Setenv("DRMAKE_TEST_TOKEN", "b") }},
+		{"env value", func(r *Runner, s *parser.Target) { r.Env = []string{"MODE=release"} }},
+		{"env file contents", func(r *Runner, s *parser.Target) {
+			ioutil.WriteFile(filepath.Join(r.Dir, ".env"), []byte("A=2\n"), 0644)
+		}},
+		{"mount", func(r *Runner, s *parser.Target) { s.Mounts = []string{"data:/data:ro"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, cleanup := newFakeRuntime(t)
+			defer cleanup()
+			os.Setenv("DRMAKE_TEST_TOKEN", "a")
+			defer os.Unsetenv("DRMAKE_TEST_TOKEN")
+			ioutil.WriteFile(filepath.Join(rt.dir, ".env"), []byte("A=1\n"), 0644)
+
+			r := New(rt, Options{Dir: rt.dir, Env: []string{"MODE=debug"}})
+			s := &parser.Target{Name: "a", Image: "alpine", PassEnv: []string{"DRMAKE_TEST_TOKEN"}, EnvFiles: []string{".env"}, Mounts: []string{"data:/data"}}
+			list := parser.Targets{"a": s}
+			before := r.digest(list, s, "FROM alpine")
+			if again := r.digest(list, s, "FROM alpine"); again != before {
+				t.Fatalf("digest changed without changes: %s != %s", again, before)
+			}
+			tt.change(r, s)
+			if after := r.digest(list, s, "FROM alpine"); after == before {
+				t.Errorf("digest did not change")
+			}
+		})
+	}
+}
+
+func TestArtifactsExist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drmake-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "bin", "app"), nil, 0644)
+
+	tests := []struct {
+		name string
+		dsts []string
+		want bool
+	}{
+		{"no artifacts", nil, true},
+		{"existing", []string{"bin/app", "bin/"}, true},
+		{"deleted", []string{"bin/app", "dist/app"}, false},
+		{"uploaded", []string{"s3://bucket/app"}, true},
+		{"stdout", []string{"-"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &parser.Target{Name: "a"}
+			for _, dst := range tt.dsts {
+				s.Artifacts = append(s.Artifacts, parser.Artifact{Src: "app", Dst: dst})
+			}
+			r := &Runner{Options: Options{Dir: dir}}
+			if got := r.artifactsExist(s); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// envArgs returns the runtime arguments that set environment variables in
+// the target's container. Host variables named by PASSENV or --env are
+// forwarded with their current values, so they never end up in an image.
+// Variables from env files are set first, so the others take precedence.
+func (r *Runner) envArgs(s *parser.Target) ([]string, error) {
+	args := []string{}
+	for _, file := range append(append([]string{}, r.EnvFile...), s.EnvFiles...) {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(r.Dir, filepath.FromSlash(file))
+		}
+		envfile, err := r.normalizeEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--env-file", envfile)
+	}
+	for _, env := range append(append([]string{}, s.PassEnv...), r.Env...) {
+		args = append(args, "-e", env)
+	}
+	return args, nil
+}
+
+// normalizeEnvFile reads a dotenv file and writes its variables to a file in
+// the temporary directory in the plainer format the runtime's --env-file
+// expects, returning its path. Dotenv files may contain comments, "export"
+// prefixes and single or double quoted values.
+func (r *Runner) normalizeEnvFile(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read env file: %v", err)
+	}
+
+	out := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			// A bare name forwards the host's value.
+			out += line + "\n"
+			continue
+		}
+		key, value := strings.TrimSpace(line[:eq]), strings.TrimSpace(line[eq+1:])
+		if value, err = unquoteEnv(value); err != nil {
+			return "", fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		if strings.Contains(value, "\n") {
+			return "", fmt.Errorf("%s:%d: multi-line values are not supported", file, i+1)
+		}
+		out += key + "=" + value + "\n"
+	}
+
+	envfile := filepath.Join(r.TempDir, fmt.Sprintf("env-%x", sha1.Sum([]byte(file))))
+	return envfile, ioutil.WriteFile(envfile, []byte(out), 0600)
+}
+
+// unquoteEnv returns the value of a dotenv assignment. Double quoted values
+// support \n, \" and \\ escapes, single quoted values are literal and inline
+// comments are removed from unquoted values.
+func unquoteEnv(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		r := strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`)
+		return r.Replace(value[1:end]), nil
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return value[1:end], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+func TestUnquoteEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"plain", "plain", false},
+		{"plain # comment", "plain", false},
+		{"a#b", "a#b", false},
+		{`"a b # c"`, "a b # c", false},
+		{`"line\nbreak \"quoted\" \\"`, "line\nbreak \"quoted\" \\", false},
+		{`'literal \n'`, `literal \n`, false},
+		{`"unterminated`, "", true},
+		{`'unterminated`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := unquoteEnv(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeEnvFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  string
+	}{
+		{"plain", "A=1\nB=2\n", "A=1\nB=2\n", ""},
+		{"comments and blank lines", "# comment\n\nA=1\n  # indented\n", "A=1\n", ""},
+		{"export", "export A=1\n", "A=1\n", ""},
+		{"quoted", "A=\"x y\"\nB='$z'\n", "A=x y\nB=$z\n", ""},
+		{"spaces around equals", "A = 1\n", "A=1\n", ""},
+		{"bare name", "HOME\n", "HOME\n", ""},
+		{"unterminated", "A=1\nB=\"x\n", "", ".env:2: unterminated quoted value"},
+		{"multi-line", "A=\"x\\ny\"\n", "", ".env:1: multi-line values are not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, cleanup := newFakeRuntime(t)
+			defer cleanup()
+			file := filepath.Join(rt.dir, ".env")
+			ioutil.WriteFile(file, []byte(tt.data), 0644)
+
+			r := New(rt, Options{Dir: rt.dir, TempDir: rt.dir})
+			envfile, err := r.normalizeEnvFile(file)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(envfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvArgs(t *testing.T) {
+	rt, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ioutil.WriteFile(filepath.Join(rt.dir, "global.env"), []byte("A=1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(rt.dir, "target.env"), []byte("B=2\n"), 0644)
+
+	r := New(rt, Options{Dir: rt.dir, TempDir: rt.dir, EnvFile: []string{"global.env"}, Env: []string{"MODE=debug"}})
+	s := &parser.Target{Name: "a", EnvFiles: []string{"target.env"}, PassEnv: []string{"TOKEN"}}
+	args, err := r.envArgs(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "--env-file" {
+			data, _ := ioutil.ReadFile(args[i+1])
+			files = append(files, string(data))
+			args[i+1] = "FILE"
+		}
+	}
+	want := []string{"--env-file", "FILE", "--env-file", "FILE", "-e", "TOKEN", "-e", "MODE=debug"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("got %q, want %q", args, want)
+	}
+	if want := []string{"A=1\n", "B=2\n"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got env files %q, want %q", files, want)
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		target   parser.Target
+		platform string
+		want     []string
+		err      string
+	}{
+		{"platform", Options{}, parser.Target{}, "linux/arm64", []string{"--platform", "linux/arm64", "-e", "TARGETPLATFORM=linux/arm64"}, ""},
+		{"mount", Options{}, parser.Target{Mounts: []string{"data:/data:ro"}}, "", []string{"-v", "DIR/data:/data:ro"}, ""},
+		{"resources", Options{CPUs: "2", Memory: "1g"}, parser.Target{Memory: "512m"}, "", []string{"--cpus", "2", "--memory", "512m"}, ""},
+		{"ports", Options{}, parser.Target{Ports: []string{"8080:80"}}, "", []string{"-p", "8080:80"}, ""},
+		{"offline", Options{Offline: true}, parser.Target{Network: "host"}, "", []string{"--network", "none"}, ""},
+		{"network", Options{}, parser.Target{Network: "host"}, "", []string{"--network", "host"}, ""},
+		{"tty", Options{TTY: true}, parser.Target{}, "", []string{"-it"}, ""},
+		{"privileged", Options{AllowPrivileged: true}, parser.Target{Privileged: true, CapAdd: []string{"NET_ADMIN"}}, "", []string{"--privileged", "--cap-add", "NET_ADMIN"}, ""},
+		{"privileged not allowed", Options{}, parser.Target{Privileged: true}, "", nil, "run with --allow-privileged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, cleanup := newFakeRuntime(t)
+			defer cleanup()
+			tt.opts.Dir = rt.dir
+			r := New(rt, tt.opts)
+			s := tt.target
+			s.Name = "a"
+			args, err := r.runArgs(&s, tt.platform)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := strings.Replace(strings.Join(args, " "), rt.dir, "DIR", -1)
+			if !strings.HasPrefix(line, "run --rm --name ") {
+				t.Errorf("got %q, want a run command", line)
+			}
+			if want := strings.Join(tt.want, " "); !strings.Contains(line, " "+want+" ") {
+				t.Errorf("got %q, want it to contain %q", line, want)
+			}
+			if tag := r.platformTag(&s, tt.platform); !strings.HasSuffix(line, " -w /work "+tag) {
+				t.Errorf("got %q, want it to end with the image %s", line, tag)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInterrupted is returned when a run is stopped by Interrupt.
+var ErrInterrupted = errors.New("interrupted")
+
+// TargetError is returned when a step of running a target fails.
+type TargetError struct {
+	Target string
+	Op     string
+	Err    error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("target %s: %s failed: %v", e.Target, e.Op, e.Err)
+}
+
+// ExitCode returns the exit status of the failed command, so that a
+// container's exit code becomes drmake's exit code.
+func (e *TargetError) ExitCode() int {
+	return ExitCode(e.Err)
+}
+
+// ExitCode returns the process exit code that err should map to.
+func ExitCode(err error) int {
+	if e, ok := err.(interface{ ExitCode() int }); ok {
+		if code := e.ExitCode(); code > 0 {
+			return code
+		}
+	}
+	return 1
+}
+
+// MultiError is returned when one or more targets fail in keep-going mode.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d target(s) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// ExitCode returns the exit code of the first failure.
+func (e MultiError) ExitCode() int {
+	return ExitCode(e[0])
+}
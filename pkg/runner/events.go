@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Event is an entry of the event stream written to the Events option as a
+// line of JSON. Fields that do not apply to the event's type are left out.
+type Event struct {
+	Time time.Time `json:"time"`
+
+	// Type is one of run_started, target_started, build_finished,
+	// container_finished, artifact_copied, target_finished and
+	// run_finished.
+	Type string `json:"event"`
+
+	Target   string   `json:"target,omitempty"`
+	Targets  []string `json:"targets,omitempty"`
+	Platform string   `json:"platform,omitempty"`
+
+	// Status is ok, failed or skipped for target_finished and ok or failed
+	// for run_finished.
+	Status string `json:"status,omitempty"`
+
+	// Duration is how long the step took in seconds. For target_finished,
+	// Build and Run are the time spent building and running the target.
+	Duration float64 `json:"duration,omitempty"`
+	Build    float64 `json:"build,omitempty"`
+	Run      float64 `json:"run,omitempty"`
+
+	// Cached is set for a build_finished event of an image reused from the
+	// image cache.
+	Cached bool `json:"cached,omitempty"`
+
+	// ExitCode is the exit code of a container_finished event.
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// AllowedFailure is set for a failed target_finished event of a target
+	// marked ALLOW_FAILURE.
+	AllowedFailure bool `json:"allowed_failure,omitempty"`
+
+	// Src and Dst are the source and destination of an artifact_copied
+	// event.
+	Src string `json:"src,omitempty"`
+	Dst string `json:"dst,omitempty"`
+}
+
+// emit writes the event to the event stream, if there is one, and adds it
+// to the trace of the run.
+func (r *Runner) emit(e Event) {
+	e.Time = time.Now().UTC()
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	if r.tracer != nil {
+		r.tracer.event(e)
+	}
+	if r.Events == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := r.Events.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write event: %v\n", err)
+	}
+}
+
+// errorEvent sets the status and error of an event from err.
+func errorEvent(e Event, err error) Event {
+	e.Status = "ok"
+	if err != nil {
+		e.Status, e.Error = "failed", err.Error()
+	}
+	return e
+}
+
+// emitFinished emits the target_finished event of a target.
+func (r *Runner) emitFinished(res *Result, err error) {
+	e := errorEvent(Event{Type: "target_finished", Target: res.Target, Build: res.Build.Seconds(), Run: res.Run.Seconds()}, err)
+	e.AllowedFailure = res.AllowedFailure
+	if err == nil && res.Status != "" {
+		e.Status = res.Status
+	}
+	r.emit(e)
+}
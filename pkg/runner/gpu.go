@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// gpuArgs returns the runtime arguments giving the target's container its
+// GPUs, or an error if the runtime cannot provide them.
+func (r *Runner) gpuArgs(s *parser.Target) ([]string, error) {
+	if s.GPUs == "" {
+		return nil, nil
+	}
+	r.gpuOnce.Do(func() { r.gpuErr = r.checkGPU() })
+	if r.gpuErr != nil {
+		return nil, fmt.Errorf("target %s requires a GPU: %v", s.Name, r.gpuErr)
+	}
+	if strings.ToLower(s.GPUs) == "all" {
+		return []string{"--gpus", "all"}, nil
+	}
+	// Device lists contain commas, so docker needs them quoted.
+	return []string{"--gpus", `"device=` + s.GPUs + `"`}, nil
+}
+
+// checkGPU returns an error if docker has no NVIDIA runtime. Other runtimes
+// are not checked.
+func (r *Runner) checkGPU() error {
+	if r.rt.Name() != "docker" {
+		return nil
+	}
+	out, err := r.rt.Command("info", "--format", "{{json .Runtimes}}").Output()
+	if err != nil {
+		return fmt.Errorf("docker info failed: %v", err)
+	}
+	if !strings.Contains(string(out), "nvidia") {
+		return fmt.Errorf("the NVIDIA container runtime is not available to docker, install the NVIDIA Container Toolkit")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyRuns is the number of runs kept in the history.
+const historyRuns = 500
+
+// HistoryRun is a run recorded in the project's history.
+type HistoryRun struct {
+	Time    time.Time       `json:"time"`
+	Targets []HistoryTarget `json:"targets"`
+}
+
+// HistoryTarget is the outcome of a target that was built or run in a
+// recorded run. Build and Run are durations in seconds.
+type HistoryTarget struct {
+	Target string  `json:"target"`
+	Status string  `json:"status"`
+	Build  float64 `json:"build"`
+	Run    float64 `json:"run"`
+}
+
+// Duration returns the total time spent on the target.
+func (t HistoryTarget) Duration() time.Duration {
+	return time.Duration((t.Build + t.Run) * float64(time.Second))
+}
+
+func (r *Runner) historyPath() string {
+	return filepath.Join(r.Dir, ".drmake", "history")
+}
+
+// LoadHistory reads the project's history, oldest run first. A missing file
+// yields an empty history.
+func (r *Runner) LoadHistory() ([]HistoryRun, error) {
+	data, err := ioutil.ReadFile(r.historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	runs := []HistoryRun{}
+	for _, line := range strings.Split(string(data), "\n") {
+		var run HistoryRun
+		if line != "" && json.Unmarshal([]byte(line), &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// saveHistory adds the targets of a run that were built or run to the
+// history, one line of JSON per run, dropping the oldest runs beyond
+// historyRuns.
+func (r *Runner) saveHistory(start time.Time, results []*Result) error {
+	run := HistoryRun{Time: start.UTC()}
+	for _, res := range results {
+		// Targets without an image take no time and are left out.
+		if res.Status == "failed" || (res.Status == "ok" && res.Build+res.Run > 0) {
+			run.Targets = append(run.Targets, HistoryTarget{Target: res.Target, Status: res.Status,
+				Build: res.Build.Seconds(), Run: res.Run.Seconds()})
+		}
+	}
+	if len(run.Targets) == 0 {
+		return nil
+	}
+	runs, err := r.LoadHistory()
+	if err != nil {
+		return err
+	}
+	runs = append(runs, run)
+	if len(runs) > historyRuns {
+		runs = runs[len(runs)-historyRuns:]
+	}
+	data := []byte{}
+	for _, run := range runs {
+		line, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(r.historyPath()), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.historyPath(), data, 0644)
+}
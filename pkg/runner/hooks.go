@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// runHook runs the shell command cmd on the host in the project directory,
+// with env added to its environment along with the DRMAKE_RUN_ID,
+// DRMAKE_PROJECT and DRMAKE_TARGETS of the current run.
+func (r *Runner) runHook(cmd string, env ...string) error {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", cmd)
+	} else {
+		c = exec.Command("sh", "-c", cmd)
+	}
+	c.Dir = r.Dir
+	c.Env = append(os.Environ(), "DRMAKE_RUN_ID="+r.runID, "DRMAKE_PROJECT="+r.Dir, "DRMAKE_TARGETS="+r.runTargets)
+	c.Env = append(c.Env, env...)
+	c.Stdout = r.stdout()
+	c.Stderr = os.Stderr
+	if err := r.runTracked(c, "", ""); err != nil {
+		return fmt.Errorf("hook %q failed: %v", cmd, err)
+	}
+	return nil
+}
+
+// targetHookEnv returns the environment of the target's hooks.
+func (r *Runner) targetHookEnv(s *parser.Target) []string {
+	return []string{"DRMAKE_TARGET=" + s.Name, "DRMAKE_IMAGE=" + r.Tag(s)}
+}
+
+// statusEnv returns the environment of post hooks that tells them how the
+// run or target went.
+func statusEnv(start time.Time, err error) []string {
+	status := "ok"
+	if err != nil {
+		status = "failed"
+	}
+	env := []string{"DRMAKE_STATUS=" + status, fmt.Sprintf("DRMAKE_DURATION=%.3f", time.Since(start).Seconds())}
+	if err != nil {
+		env = append(env, "DRMAKE_ERROR="+strings.Replace(err.Error(), "\n", " ", -1))
+	}
+	return env
+}
+
+// runTargetHooked runs the target between its HOOK pre and HOOK post
+// commands. A failing pre hook fails the target without running it, and a
+// failing post hook fails a target that succeeded. Post hooks run even if
+// the target failed.
+func (r *Runner) runTargetHooked(list parser.Targets, s *parser.Target, res *Result) error {
+	defer r.enter(s)()
+	start := time.Now()
+	env := r.targetHookEnv(s)
+	for _, hook := range s.PreHooks {
+		log.Printf("Running pre hook of target %s\n", s.Name)
+		if err := r.runHook(hook, env...); err != nil {
+			return &TargetError{Target: s.Name, Op: "pre hook", Err: err}
+		}
+	}
+	err := r.RunTarget(list, s, res)
+	if r.Interrupted() {
+		return err
+	}
+	for _, hook := range s.PostHooks {
+		log.Printf("Running post hook of target %s\n", s.Name)
+		if herr := r.runHook(hook, append(env, statusEnv(start, err)...)...); herr != nil && err == nil {
+			err = &TargetError{Target: s.Name, Op: "post hook", Err: herr}
+		}
+	}
+	return err
+}
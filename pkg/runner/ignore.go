@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const ignoreFile = ".drmakeignore"
+
+// IgnoreList is a list of patterns read from an ignore file, in gitignore
+// syntax: patterns without a slash (other than a trailing one) match any
+// path component, others match from the project root, a trailing slash
+// only matches directories, ** matches any number of directories and a
+// leading ! re-includes paths excluded by earlier patterns.
+type IgnoreList []string
+
+// LoadIgnore reads the .drmakeignore file from the project directory dir,
+// or if there is none, its .dockerignore file. A missing file yields an
+// empty list.
+func LoadIgnore(dir string) IgnoreList {
+	if data, err := ioutil.ReadFile(filepath.Join(dir, ignoreFile)); err == nil {
+		return parseIgnore(data, false)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, ".dockerignore")); err == nil {
+		return parseIgnore(data, true)
+	}
+	return IgnoreList{}
+}
+
+// parseIgnore returns the patterns of an ignore file. Patterns of
+// .dockerignore files always match from the root, so they are anchored.
+func parseIgnore(data []byte, anchored bool) IgnoreList {
+	list := IgnoreList{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.Trim(line, " \r\n")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if anchored {
+			neg := ""
+			if line[0] == '!' {
+				neg, line = "!", line[1:]
+			}
+			line = neg + "/" + strings.TrimPrefix(path.Clean(line), "/")
+		}
+		list = append(list, line)
+	}
+	return list
+}
+
+// Match returns whether the file at the slash separated path rel, relative
+// to the project directory, is ignored.
+func (l IgnoreList) Match(rel string) bool {
+	return l.match(rel, false)
+}
+
+// MatchDir returns whether the directory at rel is ignored.
+func (l IgnoreList) MatchDir(rel string) bool {
+	return l.match(rel, true)
+}
+
+func (l IgnoreList) match(rel string, dir bool) bool {
+	ignored := false
+	parts := strings.Split(rel, "/")
+	for _, pattern := range l {
+		neg := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = strings.TrimSuffix(pattern, "/")
+		anchored := strings.Contains(pattern, "/")
+		re := ignoreRegexp(strings.TrimPrefix(pattern, "/"))
+
+		// Every leading part of rel but rel itself is a directory.
+		matched := false
+		for i := range parts {
+			if dirOnly && i == len(parts)-1 && !dir {
+				break
+			}
+			s := parts[i]
+			if anchored {
+				s = strings.Join(parts[:i+1], "/")
+			}
+			if re.MatchString(s) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			ignored = !neg
+		}
+	}
+	return ignored
+}
+
+var (
+	ignoreRegexps   = map[string]*regexp.Regexp{}
+	ignoreRegexpsMu sync.Mutex
+)
+
+// ignoreRegexp returns the compiled regular expression of an ignore pattern.
+func ignoreRegexp(pattern string) *regexp.Regexp {
+	ignoreRegexpsMu.Lock()
+	defer ignoreRegexpsMu.Unlock()
+	re, ok := ignoreRegexps[pattern]
+	if !ok {
+		re = globRegexp(pattern)
+		ignoreRegexps[pattern] = re
+	}
+	return re
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// isolated returns whether the target runs in a snapshot of the workspace
+// volume rather than the workspace volume itself.
+func (r *Runner) isolated(s *parser.Target) bool {
+	return (r.Isolate || s.Isolate) && !r.Host && !s.Service
+}
+
+// snapshotVolume creates a copy of the workspace volume for the target and
+// makes it the workspace until dropSnapshot is called.
+func (r *Runner) snapshotVolume(s *parser.Target) error {
+	if err := r.checkHelper(); err != nil {
+		return err
+	}
+	snap := fmt.Sprintf("%s-%s-%d", r.wsvol(), reContainerName.ReplaceAllString(s.Name, "_"), os.Getpid())
+	if err := r.rt.Command("volume", "create", snap).Run(); err != nil {
+		return err
+	}
+	cmd := r.rt.Command("run", "--rm", "-v", r.wsvol()+":/from:ro", "-v", snap+":/work",
+		r.HelperImage, "cp", "-a", "/from/.", "/work/")
+	cmd.Stdout = r.stdout()
+	cmd.Stderr = os.Stderr
+	if err := r.runTracked(cmd, "", ""); err != nil {
+		r.rt.Command("volume", "rm", "-f", snap).Run()
+		return err
+	}
+	r.snapshot = snap
+	return nil
+}
+
+// commitSnapshot copies the ARTIFACT and SYNCBACK sources of the target from
+// its snapshot into the workspace volume, so that the targets that run after
+// it see its declared outputs and nothing else.
+func (r *Runner) commitSnapshot(s *parser.Target) error {
+	paths := []string{}
+	for _, a := range s.Artifacts {
+		if !a.Image {
+			paths = append(paths, a.Src)
+		}
+	}
+	paths = append(paths, s.SyncBack...)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	snap := r.snapshot
+	r.snapshot = ""
+	defer func() { r.snapshot = snap }()
+
+	// The sources are expanded by the shell, so that globs work, and the ones
+	// that do not exist are skipped.
+	cmd := r.rt.Command(append([]string{"run", "--rm", "-v", snap + ":/from:ro", "-v", r.wsvol() + ":/work",
+		r.HelperImage, "sh", "-c", `cd /from && for p in $*; do [ -e "$p" ] && echo "$p"; done | tar cf - -T - | tar xf - -C /work`, "sh"}, paths...)...)
+	cmd.Stdout = r.stdout()
+	cmd.Stderr = os.Stderr
+	return r.runTracked(cmd, "", "")
+}
+
+// dropSnapshot removes the target's snapshot and restores the workspace
+// volume.
+func (r *Runner) dropSnapshot() {
+	if r.snapshot == "" {
+		return
+	}
+	if err := r.rt.Command("volume", "rm", "-f", r.snapshot).Run(); err != nil {
+		log.Printf("Failed to remove volume %s: %v\n", r.snapshot, err)
+	}
+	r.snapshot = ""
+}
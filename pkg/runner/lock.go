@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+var reFromImage = regexp.MustCompile(`(?im)^(FROM\s+(?:--platform=\S+\s+)?)(\S+)(\s+AS\s+(\S+))?`)
+
+// Lock maps the base images of a project to the registry digests they are
+// pinned to.
+type Lock map[string]string
+
+func (r *Runner) lockPath() string {
+	return filepath.Join(r.Dir, ".drmake", "lock")
+}
+
+// LoadLock reads the project's lock file. A missing file yields a nil Lock.
+func (r *Runner) LoadLock() (Lock, error) {
+	data, err := ioutil.ReadFile(r.lockPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lock := Lock{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && line[0] != '#' {
+			lock[fields[0]] = fields[1]
+		}
+	}
+	return lock, nil
+}
+
+// SaveLock writes the project's lock file.
+func (r *Runner) SaveLock(lock Lock) error {
+	images := []string{}
+	for image := range lock {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	data := "# Base image digests used by drmake. Update with: drmake lock --update\n"
+	for _, image := range images {
+		data += fmt.Sprintf("%s %s\n", image, lock[image])
+	}
+	if err := os.MkdirAll(filepath.Dir(r.lockPath()), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.lockPath(), []byte(data), 0644)
+}
+
+// UpdateLock resolves the base images of all targets in list to their
+// registry digests. Images already in lock keep their digest unless update
+// is set, and images no longer used are dropped.
+func (r *Runner) UpdateLock(list parser.Targets, lock Lock, update bool) (Lock, error) {
+	images, err := baseImages(list, r.Dir)
+	if err != nil {
+		return nil, err
+	}
+	next := Lock{}
+	for _, image := range images {
+		if digest, ok := lock[image]; ok && !update {
+			next[image] = digest
+			continue
+		}
+		log.Printf("Resolving %s\n", image)
+		digest, err := r.resolveDigest(image)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %v", image, err)
+		}
+		next[image] = digest
+	}
+	return next, nil
+}
+
+// resolveDigest pulls image and returns its registry digest.
+func (r *Runner) resolveDigest(image string) (string, error) {
+	cmd := r.rt.Command("pull", "-q", image)
+	cmd.Stderr = os.Stderr
+	if err := r.runTracked(cmd, "", ""); err != nil {
+		return "", err
+	}
+	out, err := r.rt.Command("image", "inspect", "--format", "{{range .RepoDigests}}{{println .}}{{end}}", image).Output()
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range strings.Fields(string(out)) {
+		if i := strings.LastIndex(ref, "@"); i >= 0 {
+			return ref[i+1:], nil
+		}
+	}
+	return "", fmt.Errorf("image has no registry digest")
+}
+
+// baseImages returns the registry images that the Dockerfiles of the
+// targets in list are based on.
+func baseImages(list parser.Targets, dir string) ([]string, error) {
+	seen := map[string]bool{}
+	images := []string{}
+	for _, t := range list.Sorted() {
+		dfile, err := t.Dockerfile(list, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range fromImages(dfile) {
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// fromImages returns the images of the FROM lines of dfile that can be
+// pinned, skipping references to earlier build stages, scratch, images
+// that are already pinned and images named by build args.
+func fromImages(dfile string) []string {
+	stages := map[string]bool{"scratch": true}
+	images := []string{}
+	for _, m := range reFromImage.FindAllStringSubmatch(dfile, -1) {
+		image := m[2]
+		if !stages[strings.ToLower(image)] && !strings.ContainsAny(image, "$@") {
+			images = append(images, image)
+		}
+		if m[4] != "" {
+			stages[strings.ToLower(m[4])] = true
+		}
+	}
+	return images
+}
+
+// pinImages pins the FROM images of dfile to their digests in the
+// project's lock file, if it has one. Images missing from the lock file
+// are left as they are, with a warning.
+func (r *Runner) pinImages(dfile string) string {
+	r.lockOnce.Do(func() {
+		var err error
+		if r.lock, err = r.LoadLock(); err != nil {
+			log.Printf("Failed to read lock file: %v\n", err)
+		}
+	})
+	if r.lock == nil {
+		return dfile
+	}
+	pinnable := map[string]bool{}
+	for _, image := range fromImages(dfile) {
+		pinnable[image] = true
+	}
+	return reFromImage.ReplaceAllStringFunc(dfile, func(line string) string {
+		m := reFromImage.FindStringSubmatch(line)
+		image := m[2]
+		if !pinnable[image] {
+			return line
+		}
+		digest, ok := r.lock[image]
+		if !ok {
+			if !r.warnedUnlocked[image] {
+				log.Printf("Image %s is not in the lock file, run drmake lock to add it\n", image)
+				r.warnedUnlocked[image] = true
+			}
+			return line
+		}
+		return m[1] + image + "@" + digest + m[3]
+	})
+}
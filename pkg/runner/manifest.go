@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ArtifactFile is a file copied out as an artifact, as listed in the
+// artifact manifest.
+type ArtifactFile struct {
+	Target string `json:"target"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// recordArtifact records the artifact file at local, copied to path (a path
+// relative to the project directory or an upload URL), in res. With the
+// Checksums option, a .sha256 file is written next to it, in the format of
+// sha256sum.
+func (r *Runner) recordArtifact(res *Result, local, dst string) error {
+	if r.Manifest == "" && !r.Checksums {
+		return nil
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	res.Files = append(res.Files, ArtifactFile{Target: res.Target, Path: dst, Size: size, SHA256: sum})
+
+	if r.Checksums {
+		line := sum + "  " + path.Base(dst) + "\n"
+		if err := ioutil.WriteFile(local+".sha256", []byte(line), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteManifest writes the artifact files of results to the JSON file name.
+func WriteManifest(name string, results []*Result) error {
+	files := []ArtifactFile{}
+	for _, res := range results {
+		files = append(files, res.Files...)
+	}
+	data, err := json.MarshalIndent(struct {
+		Artifacts []ArtifactFile `json:"artifacts"`
+	}{files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(data, '\n'), 0644)
+}
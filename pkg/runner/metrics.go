@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var reMetricName = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// pushMetrics sends the duration, cache hits and success of every target
+// that ran, and of the run as a whole, to the StatsD server and the
+// Pushgateway, if set. Failures are only logged.
+func (r *Runner) pushMetrics(start time.Time, results []*Result, err error) {
+	if r.StatsD != "" {
+		if err := r.sendStatsD(start, results, err); err != nil {
+			log.Printf("Failed to send metrics to %s: %v\n", r.StatsD, err)
+		}
+	}
+	if r.Pushgateway != "" {
+		if err := r.sendPushgateway(start, results, err); err != nil {
+			log.Printf("Failed to push metrics to %s: %v\n", redactURL(r.Pushgateway), err)
+		}
+	}
+}
+
+// ranResults returns the results of the targets that were run or skipped
+// as unchanged, leaving out targets without an image and targets skipped
+// because of a failure.
+func ranResults(results []*Result) []*Result {
+	ran := []*Result{}
+	for _, res := range results {
+		if res.Status == "failed" || res.Cached || (res.Status == "ok" && res.Build+res.Run > 0) {
+			ran = append(ran, res)
+		}
+	}
+	return ran
+}
+
+// sendStatsD sends the metrics as StatsD timers and counters named
+// drmake.<target>.<metric>, in a single UDP packet per target.
+func (r *Runner) sendStatsD(start time.Time, results []*Result, err error) error {
+	conn, cerr := net.Dial("udp", r.StatsD)
+	if cerr != nil {
+		return cerr
+	}
+	defer conn.Close()
+
+	outcome := func(failed bool) string {
+		if failed {
+			return "failure"
+		}
+		return "success"
+	}
+	for _, res := range ranResults(results) {
+		name := "drmake." + reMetricName.ReplaceAllString(res.Target, "_")
+		lines := []string{
+			fmt.Sprintf("%s.build:%d|ms", name, res.Build/time.Millisecond),
+			fmt.Sprintf("%s.run:%d|ms", name, res.Run/time.Millisecond),
+			fmt.Sprintf("%s.%s:1|c", name, outcome(res.Status == "failed")),
+		}
+		if res.Cached {
+			lines = append(lines, name+".cache_hit:1|c")
+		} else {
+			lines = append(lines, name+".cache_miss:1|c")
+		}
+		if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+			return err
+		}
+	}
+	_, werr := conn.Write([]byte(fmt.Sprintf("drmake.run.duration:%d|ms\ndrmake.run.%s:1|c",
+		time.Since(start)/time.Millisecond, outcome(err != nil))))
+	return werr
+}
+
+// sendPushgateway replaces the metrics of the project's group in the
+// Pushgateway with the metrics of the run, in the Prometheus text format.
+// The group is keyed by job="drmake" and the project directory's name.
+func (r *Runner) sendPushgateway(start time.Time, results []*Result, err error) error {
+	var buf bytes.Buffer
+	metric := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	boolValue := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	ran := ranResults(results)
+
+	metric("drmake_target_duration_seconds", "Time spent building and running the target.")
+	for _, res := range ran {
+		fmt.Fprintf(&buf, "drmake_target_duration_seconds{target=%q,phase=\"build\"} %g\n", res.Target, res.Build.Seconds())
+		fmt.Fprintf(&buf, "drmake_target_duration_seconds{target=%q,phase=\"run\"} %g\n", res.Target, res.Run.Seconds())
+	}
+	metric("drmake_target_success", "Whether the target succeeded.")
+	for _, res := range ran {
+		fmt.Fprintf(&buf, "drmake_target_success{target=%q} %d\n", res.Target, boolValue(res.Status != "failed"))
+	}
+	metric("drmake_target_cache_hit", "Whether the target was skipped as unchanged or its image was reused.")
+	for _, res := range ran {
+		fmt.Fprintf(&buf, "drmake_target_cache_hit{target=%q} %d\n", res.Target, boolValue(res.Cached))
+	}
+	metric("drmake_run_duration_seconds", "Duration of the run.")
+	fmt.Fprintf(&buf, "drmake_run_duration_seconds %g\n", time.Since(start).Seconds())
+	metric("drmake_run_success", "Whether the run succeeded.")
+	fmt.Fprintf(&buf, "drmake_run_success %d\n", boolValue(err == nil))
+	metric("drmake_run_timestamp_seconds", "When the run started.")
+	fmt.Fprintf(&buf, "drmake_run_timestamp_seconds %d\n", start.Unix())
+
+	url := strings.TrimSuffix(r.Pushgateway, "/") + "/metrics/job/drmake/project/" + escapePath(filepath.Base(r.Dir))
+	req, rerr := http.NewRequest("PUT", url, &buf)
+	if rerr != nil {
+		return rerr
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, rerr := client.Do(req)
+	if rerr != nil {
+		return rerr
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
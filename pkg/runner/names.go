@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+)
+
+// ProjectID identifies the project for the purpose of naming its volumes
+// and images. It is derived from the absolute path of the build file so that
+// projects using the same build file name do not share a workspace.
+func (r *Runner) ProjectID() string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(r.makefilePath())))
+}
+
+// makefilePath returns the absolute path of the build file.
+func (r *Runner) makefilePath() string {
+	if filepath.IsAbs(r.Makefile) {
+		return filepath.Clean(r.Makefile)
+	}
+	return filepath.Join(r.rootDir(), r.Makefile)
+}
+
+// VolumeName returns the name of the kind ("ws" or "cache") volume. Names
+// start with the VolumePrefix option if set, and the volumes of SUBDIR
+// projects have a suffix derived from their directory.
+func (r *Runner) VolumeName(kind string) string {
+	if r.VolumePrefix != "" {
+		return r.VolumePrefix + "-" + kind + r.subdirSuffix()
+	}
+	return fmt.Sprintf("drmake-%s-%s%s", kind, r.ProjectID(), r.subdirSuffix())
+}
+
+// LegacyVolumeName returns the name that older versions of drmake gave the
+// kind volume, which was keyed on the build file name only.
+func (r *Runner) LegacyVolumeName(kind string) string {
+	return fmt.Sprintf("drmake-%s-%x", kind, sha1.Sum([]byte(r.Makefile)))
+}
+
+func (r *Runner) wsvol() string {
+	if r.Host {
+		return r.Dir
+	}
+	if r.snapshot != "" {
+		return r.snapshot
+	}
+	return r.VolumeName("ws")
+}
+
+func (r *Runner) cachevol() string {
+	return r.VolumeName("cache")
+}
+
+// cachevolFor returns the name of the cache volume mounted at dir by the
+// CACHE directive. Unlike the workspace volume, it is kept by Fresh runs.
+func (r *Runner) cachevolFor(dir string) string {
+	return fmt.Sprintf("%s-%.6x", r.cachevol(), sha1.Sum([]byte(dir)))
+}
+
+// Image returns the repository that the project's target images are
+// tagged in.
+func (r *Runner) Image() string {
+	return "drmake-" + r.ProjectID()
+}
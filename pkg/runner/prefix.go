@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// prefixColors are the ANSI colors that target names are printed in.
+var prefixColors = []string{"36", "33", "32", "35", "34", "31", "96", "93", "92", "95", "94", "91"}
+
+// prefixWriter writes every line written to it to w, preceded by prefix.
+// An incomplete last line is held back until Flush.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+	mu     sync.Mutex
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(data), nil
+		}
+		line := append(append([]byte{}, p.prefix...), p.buf[:i+1]...)
+		p.buf = p.buf[i+1:]
+		if _, err := p.w.Write(line); err != nil {
+			return len(data), err
+		}
+	}
+}
+
+// Flush writes the incomplete last line, if any.
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		p.w.Write(append(append(append([]byte{}, p.prefix...), p.buf...), '\n'))
+		p.buf = nil
+	}
+}
+
+// prefix returns the name of the target padded to the longest target name
+// of the run, in a color that is the same for the target in every run
+// unless the NoColor option is set, followed by a separator.
+func (r *Runner) prefix(s *parser.Target) string {
+	name := fmt.Sprintf("%-*s |", r.prefixWidth, s.Name)
+	if r.NoColor {
+		return name + " "
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s.Name))
+	return "\x1b[" + prefixColors[h.Sum32()%uint32(len(prefixColors))] + "m" + name + "\x1b[0m "
+}
+
+// output returns the writers that the output of the target's commands goes
+// to, and a function flushing them once the commands are done. When a run
+// has more than one target, every line is prefixed with the name of the
+// target it belongs to, except with a TTY, which must be passed through
+// as is. The output is also kept for the report, if one is written.
+func (r *Runner) output(s *parser.Target) (stdout, stderr io.Writer, flush func()) {
+	stdout, stderr, flush = r.stdout(), os.Stderr, func() {}
+	if r.prefixWidth > 0 && !r.TTY {
+		prefix := []byte(r.prefix(s))
+		out := &prefixWriter{w: stdout, prefix: prefix}
+		errOut := &prefixWriter{w: stderr, prefix: prefix}
+		stdout, stderr = out, errOut
+		flush = func() {
+			out.Flush()
+			errOut.Flush()
+		}
+	}
+	if r.capture != nil {
+		stdout, stderr = io.MultiWriter(stdout, r.capture), io.MultiWriter(stderr, r.capture)
+	}
+	return stdout, stderr, flush
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// reportOutputSize is how much of the end of a target's output is kept for
+// the report.
+const reportOutputSize = 64 << 10
+
+// tailBuffer keeps the last reportOutputSize bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.data = append(t.data, p...)
+	if n := len(t.data); n > reportOutputSize {
+		t.data = t.data[n-reportOutputSize:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.data)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// reportResult is a target result as written to a JSON report.
+type reportResult struct {
+	Target    string   `json:"target"`
+	Status    string   `json:"status"`
+	Build     float64  `json:"build"`
+	Run       float64  `json:"run"`
+	Error     string   `json:"error,omitempty"`
+	Output    string   `json:"output,omitempty"`
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	AllowedFailure bool `json:"allowed_failure,omitempty"`
+}
+
+// WriteReport writes the results of a run to the file name, as a JUnit XML
+// report if its name ends with .xml and as JSON otherwise. Every target is
+// a test case, whose output is included if it failed.
+func WriteReport(name string, results []*Result) error {
+	var data []byte
+	var err error
+	if strings.HasSuffix(strings.ToLower(name), ".xml") {
+		data, err = junitReport(results)
+	} else {
+		data, err = jsonReport(results)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(data, '\n'), 0644)
+}
+
+func junitReport(results []*Result) ([]byte, error) {
+	suite := junitTestSuite{Name: "drmake", Tests: len(results)}
+	for _, res := range results {
+		tc := junitTestCase{Name: res.Target, ClassName: "drmake", Time: (res.Build + res.Run).Seconds()}
+		switch {
+		case res.AllowedFailure:
+			// Allowed failures are reported as skipped so that they do not
+			// fail the CI test report.
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{Message: "allowed failure: " + res.Error}
+			tc.SystemOut = res.Output
+		case res.Status == "failed":
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: res.Error, Output: res.Output}
+		case res.Status == "" || res.Status == "skipped":
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{}
+		}
+		suite.Time += tc.Time
+		suite.Cases = append(suite.Cases, tc)
+	}
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	return append([]byte(xml.Header), data...), err
+}
+
+func jsonReport(results []*Result) ([]byte, error) {
+	list := []reportResult{}
+	for _, res := range results {
+		status := res.Status
+		if status == "" {
+			status = "skipped"
+		}
+		rr := reportResult{Target: res.Target, Status: status, Build: res.Build.Seconds(), Run: res.Run.Seconds(),
+			Error: res.Error, Artifacts: res.Artifacts, AllowedFailure: res.AllowedFailure}
+		if status == "failed" {
+			rr.Output = res.Output
+		}
+		list = append(list, rr)
+	}
+	return json.MarshalIndent(struct {
+		Targets []reportResult `json:"targets"`
+	}{list}, "", "  ")
+}
@@ -0,0 +1,32 @@
+package runner
+
+import (
+	"log"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// maxRetries returns how many times a failed container run of the target
+// is retried.
+func (r *Runner) maxRetries(s *parser.Target) int {
+	if s.Retries >= 0 {
+		return s.Retries
+	}
+	return r.Retries
+}
+
+// withRetries calls fn until it succeeds, the run is interrupted or the
+// target's retries are used up, returning the last error.
+func (r *Runner) withRetries(s *parser.Target, fn func() error) error {
+	retries := r.maxRetries(s)
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || r.Interrupted() || attempt > retries {
+			return err
+		}
+		log.Printf("Target %s failed: %v (retry %d of %d in %v)\n",
+			s.Name, err, attempt, retries, s.RetryDelay)
+		time.Sleep(s.RetryDelay)
+	}
+}
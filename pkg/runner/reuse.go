@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// buildDigest returns a content hash of everything that goes into the
+// target's image for platform: the generated Dockerfile, the build args and
+// the images it copies files from. It does not depend on the project's
+// location, so the same image gets the same digest on every machine.
+func (r *Runner) buildDigest(list parser.Targets, s *parser.Target, dfile, platform string) string {
+	h := sha1.New()
+	io.WriteString(h, dfile)
+	io.WriteString(h, "\x00platform:"+platform)
+	for _, arg := range r.Args {
+		io.WriteString(h, "\x00arg:"+arg)
+	}
+	names := []string{}
+	for name := range s.Defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		io.WriteString(h, "\x00default:"+name+"="+s.Defaults[name])
+	}
+	for _, id := range r.copyFromImageIDs(list, s, dfile) {
+		io.WriteString(h, "\x00image:"+id)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// buildOrReuse builds the target's image for platform from dfile, unless
+// the ImageCache option is set and an image with the same build digest
+// exists locally or in the ImageCache repository. Newly built images are
+// pushed to the repository for other machines to reuse. reused is set if
+// an image was reused.
+func (r *Runner) buildOrReuse(list parser.Targets, s *parser.Target, dfile, platform string) (reused bool, err error) {
+	resolved := r.resolveCopyFrom(list, dfile, platform)
+	if r.ImageCache == "" || r.Fresh {
+		return false, r.build(s, resolved, platform)
+	}
+
+	cached := strings.TrimSuffix(r.ImageCache, "/") + "/" + s.Name + ":" + r.buildDigest(list, s, r.pinImages(dfile), platform)
+	if r.imageExists(cached) || r.pull(cached, platform) == nil {
+		log.Printf("Reusing image %s for target %s\n", cached, s.Name)
+		return true, r.rt.Command("tag", cached, r.platformTag(s, platform)).Run()
+	}
+
+	if err := r.build(s, resolved, platform); err != nil {
+		return false, err
+	}
+	if err := r.rt.Command("tag", r.platformTag(s, platform), cached).Run(); err != nil {
+		return false, err
+	}
+	log.Printf("Pushing %s\n", cached)
+	cmd := r.rt.Command("push", cached)
+	cmd.Stdout = r.stdout()
+	cmd.Stderr = os.Stderr
+	if err := r.runTracked(cmd, "", ""); err != nil {
+		// The image was built, so failing to share it is not fatal.
+		log.Printf("Failed to push %s: %v\n", cached, err)
+	}
+	return false, nil
+}
+
+// pull pulls image for platform quietly.
+func (r *Runner) pull(image, platform string) error {
+	args := []string{"pull", "-q"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	return r.runTracked(r.rt.Command(append(args, image)...), "", "")
+}
@@ -0,0 +1,877 @@
+// Package runner builds and runs drmake targets with a container runtime.
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/graph"
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// Options configure a Runner.
+type Options struct {
+	// Dir is the project directory, which is copied into the workspace
+	// volume and receives artifacts.
+	Dir string
+
+	// Makefile is the path of the build file, used to name the project's
+	// volumes and images.
+	Makefile string
+
+	// TempDir is an empty directory used as the build context and for
+	// temporary files.
+	TempDir string
+
+	// Args are the -a arguments passed to builds as build args.
+	Args []string
+
+	// CommandArgs replace the command of the last target's container.
+	CommandArgs []string
+
+	Fresh             bool
+	Host              bool
+	RemoveInterrupted bool
+	BuildKit          bool
+	Push              bool
+	Platform          string
+	TTY               bool
+	Timeout           time.Duration
+	Retries           int
+	KeepGoing         bool
+	VolumePrefix      string
+	HelperImage       string
+	Resume            bool
+	HealthTimeout     time.Duration
+	Env               []string
+	EnvFile           []string
+	Incremental       bool
+
+	// AlwaysMake runs targets with SOURCES even if their inputs are
+	// unchanged since their last successful run.
+	AlwaysMake bool
+
+	// CacheFrom and CacheTo are registry repositories (or full cache
+	// specs) that every target imports its build cache from and exports it
+	// to, in addition to its CACHE_FROM and CACHE_TO directives.
+	CacheFrom []string
+	CacheTo   []string
+
+	// CPUs, Memory and ShmSize limit the containers of targets without
+	// CPUS, MEMORY and SHM_SIZE directives.
+	CPUs    string
+	Memory  string
+	ShmSize string
+
+	// ArtifactTime, if set, is given to every file copied out as an
+	// artifact instead of its modification time in the workspace, so that
+	// artifacts can be archived reproducibly.
+	ArtifactTime time.Time
+
+	// Manifest is the path of a JSON file, relative to Dir, that lists the
+	// path, size and SHA-256 of every artifact file copied by a run.
+	// Checksums writes a .sha256 file next to each of them.
+	Manifest  string
+	Checksums bool
+
+	// Report is the path of a file, relative to Dir, that the results of a
+	// run are written to, as a JUnit XML report if it ends with .xml and as
+	// JSON otherwise.
+	Report string
+
+	// User runs the containers of all targets as the invoking user in host
+	// mode, as USER_MAP does for a single target.
+	User bool
+
+	// Offline runs every container without network access and builds
+	// images without it, overriding NETWORK directives. Services are still
+	// reachable over an internal network.
+	Offline bool
+
+	// AllowPrivileged permits targets to use PRIVILEGED, CAP_ADD, DEVICE
+	// and DOCKER_SOCKET. Without it, running such a target fails.
+	AllowPrivileged bool
+
+	// Isolate runs every target in its own copy of the workspace volume, as
+	// ISOLATE does for a single target.
+	Isolate bool
+
+	// Verbose logs every runtime command, generated Dockerfile and file
+	// synced into the workspace volume. Quiet hides the output of image
+	// builds unless they fail.
+	Verbose bool
+	Quiet   bool
+
+	// NoColor prints target names before their output without colors.
+	NoColor bool
+
+	// Wait makes a run wait for another run in the same project to finish
+	// instead of failing. Force runs anyway.
+	Wait  bool
+	Force bool
+
+	// Events receives a line of JSON for every Event of a run.
+	Events io.Writer
+
+	// PreRun and PostRun are shell commands run on the host before the
+	// first and after the last target of every run.
+	PreRun  []string
+	PostRun []string
+
+	// StatsD is the host:port of a StatsD server, and Pushgateway the URL
+	// of a Prometheus Pushgateway, that the metrics of every run are sent
+	// to.
+	StatsD      string
+	Pushgateway string
+
+	// OTelEndpoint is the OTLP/HTTP endpoint that a trace of every run is
+	// exported to.
+	OTelEndpoint string
+
+	// Pull always pulls base images when building, unless a target's PULL
+	// is never. NoCache builds images without the layer cache.
+	Pull    bool
+	NoCache bool
+
+	// ImageCache is a registry repository in which target images are
+	// looked up by their build digest before building them, and pushed to
+	// after building them.
+	ImageCache string
+}
+
+// Runner builds and runs targets.
+type Runner struct {
+	Options
+
+	rt       Runtime
+	inflight inflight
+
+	// remote is set when the runtime's daemon is on another machine.
+	remote bool
+
+	// gpuErr is the result of checking for GPU support, which is done once
+	// when first needed.
+	gpuErr  error
+	gpuOnce sync.Once
+
+	// helperErr is the result of checking that the helper image can run
+	// the commands that change the workspace volume, which is done once
+	// when first needed.
+	helperErr  error
+	helperOnce sync.Once
+
+	// lock is the project's lock file, read once when first needed.
+	lock           Lock
+	lockOnce       sync.Once
+	warnedUnlocked map[string]bool
+
+	// last is the target whose container receives CommandArgs.
+	last *parser.Target
+
+	// servicenet is the network that service containers and the containers
+	// of targets using them are attached to. It is empty until a service
+	// runs.
+	servicenet string
+
+	// started are the services started by the current run, which are
+	// stopped when it finishes.
+	started []*parser.Target
+
+	// stdoutArtifact is set when the current run streams an artifact to
+	// stdout, so that all other output must go to stderr.
+	stdoutArtifact bool
+
+	// used are the volumes and image repositories used by the current run,
+	// which are added to the usage record when it finishes.
+	used map[string]bool
+
+	// prefixWidth is the length of the longest target name of the current
+	// run, which the output of its targets is prefixed with, or 0 if the
+	// run has a single target.
+	prefixWidth int
+
+	eventsMu sync.Mutex
+
+	// runID identifies the current run, and runTargets lists its targets,
+	// for hooks.
+	runID      string
+	runTargets string
+	tracer     *tracer
+
+	// capture receives the output of the current target for the report.
+	capture *tailBuffer
+
+	// snapshot is the copy of the workspace volume that the current target
+	// runs in when it is isolated.
+	snapshot string
+
+	// root is the main project directory while the runner is in the
+	// directory of a SUBDIR project, and empty otherwise.
+	root string
+}
+
+// New returns a Runner that uses rt to build and run targets.
+func New(rt Runtime, opts Options) *Runner {
+	r := &Runner{Options: opts, rt: rt, remote: rt.Remote(), warnedUnlocked: map[string]bool{}}
+	if opts.OTelEndpoint != "" {
+		r.tracer = &tracer{endpoint: opts.OTelEndpoint}
+	}
+	return r
+}
+
+// stdout returns where the output of builds and containers is written,
+// which is stderr when the run streams an artifact to stdout.
+func (r *Runner) stdout() io.Writer {
+	if r.stdoutArtifact || r.Events == io.Writer(os.Stdout) {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Runtime returns the runner's container runtime.
+func (r *Runner) Runtime() Runtime {
+	return r.rt
+}
+
+// Run runs the targets named by names and their dependencies.
+func (r *Runner) Run(list parser.Targets, names []string) (err error) {
+	runTargets, err := graph.ExecOrder(list, names)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	targetNames := []string{}
+	for _, target := range runTargets {
+		targetNames = append(targetNames, target.Name)
+	}
+	r.runID, r.runTargets = newSpanID(8), strings.Join(targetNames, " ")
+	r.emit(Event{Type: "run_started", Targets: targetNames})
+	defer func() {
+		e := errorEvent(Event{Type: "run_finished", Duration: time.Since(start).Seconds()}, err)
+		r.emit(e)
+	}()
+	release, err := r.acquireRun()
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer func() {
+		if err := r.saveUsage(); err != nil {
+			log.Printf("Failed to save usage record: %v\n", err)
+		}
+	}()
+	r.last = runTargets[len(runTargets)-1]
+	r.prefixWidth = 0
+	if len(runTargets) > 1 {
+		for _, target := range runTargets {
+			if len(target.Name) > r.prefixWidth {
+				r.prefixWidth = len(target.Name)
+			}
+		}
+	}
+	for _, target := range runTargets {
+		for _, a := range target.Artifacts {
+			if a.Dst == "-" {
+				r.stdoutArtifact = true
+			}
+		}
+	}
+	results := make([]*Result, len(runTargets))
+	for i, target := range runTargets {
+		results[i] = &Result{Target: target.Name}
+	}
+	if len(runTargets) > 1 {
+		defer func() { PrintSummary(os.Stderr, results) }()
+	}
+	if r.Manifest != "" {
+		defer func() {
+			name := r.Manifest
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(r.Dir, name)
+			}
+			if err := WriteManifest(name, results); err != nil {
+				log.Printf("Failed to write artifact manifest: %v\n", err)
+			}
+		}()
+	}
+
+	defer func() {
+		r.pushMetrics(start, results, err)
+	}()
+	defer func() {
+		if err := r.saveHistory(start, results); err != nil {
+			log.Printf("Failed to save run history: %v\n", err)
+		}
+	}()
+	if r.Report != "" {
+		defer func() {
+			name := r.Report
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(r.Dir, name)
+			}
+			if err := WriteReport(name, results); err != nil {
+				log.Printf("Failed to write report: %v\n", err)
+			}
+		}()
+	}
+
+	for _, hook := range r.PreRun {
+		log.Printf("Running pre-run hook\n")
+		if err := r.runHook(hook); err != nil {
+			return err
+		}
+	}
+	if len(r.PostRun) > 0 {
+		defer func() {
+			for _, hook := range r.PostRun {
+				log.Printf("Running post-run hook\n")
+				if herr := r.runHook(hook, statusEnv(start, err)...); herr != nil {
+					log.Print(herr)
+				}
+			}
+		}()
+	}
+
+	// The workspace of each project is only prepared once a target of the
+	// project that has an image runs.
+	prepared := map[string]bool{}
+	prepare := func(s *parser.Target) {
+		defer r.enter(s)()
+		if !prepared[r.Dir] {
+			r.prepVolume()
+			prepared[r.Dir] = true
+		}
+	}
+	defer r.stopServices()
+	defer func() {
+		err = r.runCleanup(list, runTargets, &results, prepare, err)
+	}()
+	state := runState{}
+	resuming := r.Resume
+	if resuming {
+		state = r.loadState()
+	}
+	sources := r.loadSources()
+	var errs MultiError
+	failed := map[string]bool{}
+	// changed are the targets that ran in this run, which the targets that
+	// depend on them cannot be skipped for.
+	changed := map[string]bool{}
+	for i, target := range runTargets {
+		if r.Interrupted() {
+			return ErrInterrupted
+		}
+		if dep := failedDep(target, failed); dep != "" {
+			log.Printf("Skipping target %s because %s failed\n", target.Name, dep)
+			failed[target.Name] = true
+			r.emit(Event{Type: "target_finished", Target: target.Name, Status: "skipped"})
+			continue
+		}
+		digest := r.inputDigest(list, target)
+		if resuming && !target.Service {
+			if d, ok := state[target.Name]; ok && d != "" && d == digest {
+				log.Printf("Skipping target %s, it succeeded in the previous run\n", target.Name)
+				results[i].Status = "skipped"
+				r.emit(Event{Type: "target_finished", Target: target.Name, Status: "skipped"})
+				continue
+			}
+			resuming = false
+		}
+		if r.upToDate(target, sources, digest, changed) {
+			log.Printf("Skipping target %s, its SOURCES are unchanged since its last successful run\n", target.Name)
+			results[i].Status = "skipped"
+			r.emit(Event{Type: "target_finished", Target: target.Name, Status: "skipped"})
+			continue
+		}
+		if !target.Phony {
+			prepare(target)
+		}
+		r.markUsed(target)
+		r.emit(Event{Type: "target_started", Target: target.Name})
+		err := r.runTargetHooked(list, target, results[i])
+		results[i].AllowedFailure = err != nil && target.AllowFailure && !r.Interrupted()
+		r.emitFinished(results[i], err)
+		if results[i].AllowedFailure {
+			// Dependent targets still run, as if the target succeeded.
+			log.Printf("Target %s failed, which it is allowed to: %v\n", target.Name, err)
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			delete(state, target.Name)
+			r.saveState(state)
+			r.forgetSources(sources, target)
+			changed[target.Name] = true
+			continue
+		}
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			delete(state, target.Name)
+			r.saveState(state)
+			r.forgetSources(sources, target)
+			if !r.KeepGoing || r.Interrupted() {
+				return err
+			}
+			log.Print(err)
+			errs = append(errs, err)
+			failed[target.Name] = true
+			continue
+		}
+		if results[i].Status == "" {
+			results[i].Status = "ok"
+		}
+		state[target.Name] = digest
+		if err := r.saveState(state); err != nil {
+			log.Printf("Failed to save run state: %v\n", err)
+		}
+		if len(target.Sources) > 0 && digest != "" {
+			sources[target.Name] = digest
+			if err := r.saveSources(sources); err != nil {
+				log.Printf("Failed to save SOURCES digests: %v\n", err)
+			}
+		}
+		changed[target.Name] = !target.Service && results[i].Status == "ok" && (!target.Phony || changedDep(target, changed))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	r.clearState()
+	return nil
+}
+
+// failedDep returns the name of a dependency of the target that is in
+// failed, or an empty string.
+func failedDep(s *parser.Target, failed map[string]bool) string {
+	for _, dep := range s.Deps {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// RunTarget builds and runs a single target, recording the outcome in res.
+// Dependencies are not run. Targets without an image (TARGET) do nothing.
+func (r *Runner) RunTarget(list parser.Targets, s *parser.Target, res *Result) error {
+	if s.Phony {
+		return nil
+	}
+	defer r.enter(s)()
+
+	dfile, err := s.Dockerfile(list, r.Dir)
+	if err != nil {
+		return err
+	}
+
+	if r.Report != "" {
+		r.capture = &tailBuffer{}
+		defer func() {
+			res.Output = r.capture.String()
+			r.capture = nil
+		}()
+	}
+
+	if s.Service {
+		return r.startService(s, r.resolveCopyFrom(list, dfile, r.platforms(s)[0]))
+	}
+
+	// A local tag (and so --push) can only refer to a single platform's
+	// image, so fail before building rather than drop the tags.
+	platforms := r.platforms(s)
+	if len(platforms) > 1 && len(s.Tags) > 0 {
+		return &TargetError{Target: s.Name, Op: "tag", Err: fmt.Errorf("TAG requires a single platform, but the target is built for %s", strings.Join(platforms, ","))}
+	}
+
+	var digest string
+	if r.Incremental && !r.Fresh {
+		digest = r.digest(list, s, dfile)
+		if r.digestTagged(s, digest, platforms) && r.artifactsExist(s) {
+			log.Printf("Skipping unchanged target %s\n", s.Name)
+			res.Status = "skipped"
+			res.Cached = true
+			return nil
+		}
+	}
+
+	if r.isolated(s) {
+		log.Printf("Isolating target %s\n", s.Name)
+		if err := r.snapshotVolume(s); err != nil {
+			return &TargetError{Target: s.Name, Op: "isolate", Err: err}
+		}
+		defer r.dropSnapshot()
+	}
+
+	if dfile != "" || !strings.HasPrefix(s.Image, "#") {
+		for _, platform := range platforms {
+			if platform != "" {
+				log.Printf("Running target %s for %s\n", s.Name, platform)
+			}
+
+			start := time.Now()
+			reused, err := r.buildOrReuse(list, s, dfile, platform)
+			res.Build += time.Since(start)
+			res.Cached = reused
+			r.emit(errorEvent(Event{Type: "build_finished", Target: s.Name, Platform: platform, Duration: time.Since(start).Seconds(), Cached: reused}, err))
+			if err != nil {
+				return &TargetError{Target: s.Name, Op: "build", Err: err}
+			}
+
+			rargs, err := r.runArgs(s, platform)
+			if err != nil {
+				return &TargetError{Target: s.Name, Op: "run", Err: err}
+			}
+			start = time.Now()
+			err = r.withRetries(s, func() error {
+				cmd := r.rt.Command(rargs...)
+				cmd.Stdin = os.Stdin
+				stdout, stderr, flush := r.output(s)
+				defer flush()
+				cmd.Stdout, cmd.Stderr = stdout, stderr
+				return r.runWithTimeout(cmd, r.containerName(s), r.platformTag(s, platform), r.runTimeout(s))
+			})
+			res.Run += time.Since(start)
+			e := errorEvent(Event{Type: "container_finished", Target: s.Name, Platform: platform, Duration: time.Since(start).Seconds()}, err)
+			code := 0
+			if err != nil {
+				code = ExitCode(err)
+			}
+			e.ExitCode = &code
+			r.emit(e)
+			if err != nil {
+				return &TargetError{Target: s.Name, Op: "run", Err: err}
+			}
+		}
+
+		for _, tag := range s.Tags {
+			if err := r.rt.Command("tag", r.platformTag(s, platforms[0]), tag).Run(); err != nil {
+				return &TargetError{Target: s.Name, Op: "tag " + tag, Err: err}
+			}
+		}
+	}
+
+	helper := r.HelperImage
+	if dfile != "" {
+		helper = r.platformTag(s, platforms[0])
+	}
+	if len(s.Artifacts) > 0 {
+		for _, a := range s.Artifacts {
+			if a.Image && dfile == "" {
+				return &TargetError{Target: s.Name, Op: "artifact " + a.Src, Err: fmt.Errorf("ARTIFACT IMAGE requires a target that builds an image")}
+			}
+			switch {
+			case a.Dst == "-":
+				log.Printf("Streaming artifact %s to stdout\n", a.Src)
+			case IsUpload(a.Dst):
+				log.Printf("Uploading artifact %s to %s\n", a.Src, redactURL(a.Dst))
+			default:
+				log.Printf("Copying artifact %s to %s\n", a.Src, filepath.Join(r.Dir, filepath.FromSlash(a.Dst)))
+			}
+			start := time.Now()
+			if err := r.copyArtifact(a, helper, res); err != nil {
+				return &TargetError{Target: s.Name, Op: "artifact " + a.Src, Err: err}
+			}
+			r.emit(Event{Type: "artifact_copied", Target: s.Name, Src: a.Src, Dst: redactURL(a.Dst), Duration: time.Since(start).Seconds()})
+			res.Artifacts = append(res.Artifacts, a.Dst)
+		}
+	}
+	if !r.Host {
+		for _, p := range s.SyncBack {
+			log.Printf("Syncing %s back to %s\n", p, r.Dir)
+			if err := r.syncBack(p, helper); err != nil {
+				return &TargetError{Target: s.Name, Op: "sync back " + p, Err: err}
+			}
+		}
+	}
+	if r.snapshot != "" {
+		if err := r.commitSnapshot(s); err != nil {
+			return &TargetError{Target: s.Name, Op: "isolate", Err: err}
+		}
+	}
+
+	if r.Push && dfile != "" {
+		for _, tag := range s.Tags {
+			log.Printf("Pushing %s\n", tag)
+			cmd := r.rt.Command("push", tag)
+			cmd.Stdout = r.stdout()
+			cmd.Stderr = os.Stderr
+			if err := r.runTracked(cmd, "", ""); err != nil {
+				return &TargetError{Target: s.Name, Op: "push " + tag, Err: err}
+			}
+		}
+	}
+
+	if digest != "" && dfile != "" {
+		for _, platform := range platforms {
+			tag := r.digestTag(s, digest, platform)
+			if err := r.rt.Command("tag", r.platformTag(s, platform), tag).Run(); err != nil {
+				return &TargetError{Target: s.Name, Op: "tag " + tag, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// Tag returns the image name of the target.
+func (r *Runner) Tag(s *parser.Target) string {
+	return r.imageOf(s.Name)
+}
+
+// imageOf returns the image repository of the target named name. The
+// colons of namespaced names become path components, since a colon would
+// start the image's tag.
+func (r *Runner) imageOf(name string) string {
+	return r.Image() + "/" + strings.Replace(name, ":", "/", -1)
+}
+
+// build builds the target's image for platform from dfile.
+func (r *Runner) build(s *parser.Target, dfile, platform string) error {
+	cmd := r.rt.BuildCommand(r.platformTag(s, platform), platform, r.buildArgs(s, platform)...)
+	if r.buildKit(s) {
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	}
+	// Runtimes that take the Dockerfile with -f use the (empty) working
+	// directory as the build context.
+	cmd.Dir = r.TempDir
+	cmd.Stdin = strings.NewReader(r.pinImages(dfile))
+	if r.Verbose {
+		log.Printf("Dockerfile of target %s:\n%s", s.Name, r.pinImages(dfile))
+	}
+	stdout, stderr, flush := r.output(s)
+	defer flush()
+	if !r.Quiet {
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		return r.runTracked(cmd, "", r.platformTag(s, platform))
+	}
+
+	// The output is only shown if the build fails.
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := r.runTracked(cmd, "", r.platformTag(s, platform))
+	if err != nil && err != ErrInterrupted {
+		stderr.Write(out.Bytes())
+	}
+	return err
+}
+
+// buildKit returns whether the target's image is built with BuildKit.
+func (r *Runner) buildKit(s *parser.Target) bool {
+	return r.BuildKit || len(s.Secrets) > 0 || len(s.SSH) > 0 ||
+		len(r.cacheFrom(s, "")) > 0 || len(r.cacheTo(s, "")) > 0
+}
+
+// buildArgs returns the extra runtime arguments used to build the target's
+// image for platform.
+func (r *Runner) buildArgs(s *parser.Target, platform string) []string {
+	args := []string{}
+	if s.Pull == "always" || (r.Pull && s.Pull != "never") {
+		args = append(args, "--pull")
+	}
+	if r.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if r.Offline {
+		args = append(args, "--network", "none")
+	}
+	for _, arg := range r.Args {
+		args = append(args, "--build-arg", arg)
+	}
+	names := []string{}
+	for name := range s.Defaults {
+		if !parser.HasArg(r.Args, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--build-arg", name+"="+s.Defaults[name])
+	}
+	for _, secret := range s.Secrets {
+		args = append(args, "--secret", secret)
+	}
+	for _, ssh := range s.SSH {
+		args = append(args, "--ssh", ssh)
+	}
+	for _, cache := range r.cacheFrom(s, platform) {
+		args = append(args, "--cache-from", cache)
+	}
+	for _, cache := range r.cacheTo(s, platform) {
+		args = append(args, "--cache-to", cache)
+	}
+	return args
+}
+
+// platforms returns the platforms the target is built and run for. A
+// single empty platform means the runtime's default platform.
+func (r *Runner) platforms(s *parser.Target) []string {
+	platform := s.Platform
+	if r.Platform != "" {
+		platform = r.Platform
+	}
+	if platform == "" {
+		return []string{""}
+	}
+	return strings.Split(platform, ",")
+}
+
+// platformTag returns the image name of the target built for platform.
+func (r *Runner) platformTag(s *parser.Target, platform string) string {
+	if platform == "" {
+		return r.Tag(s)
+	}
+	return r.Tag(s) + ":" + strings.Replace(platform, "/", "-", -1)
+}
+
+// commandArgs returns the arguments that replace the command of the
+// target's container.
+func (r *Runner) commandArgs(s *parser.Target) []string {
+	if s == r.last {
+		return r.CommandArgs
+	}
+	return nil
+}
+
+// runArgs returns the runtime arguments used to run the target's container
+// for platform.
+func (r *Runner) runArgs(s *parser.Target, platform string) ([]string, error) {
+	if err := r.checkMounts(s); err != nil {
+		return nil, err
+	}
+	args := []string{"run", "--rm", "--name", r.containerName(s),
+		"-v", r.cachevol() + ":/root", "-v", r.wsvol() + ":/work"}
+	if platform != "" {
+		args = append(args, "--platform", platform, "-e", "TARGETPLATFORM="+platform)
+	}
+	for _, dir := range s.Caches {
+		args = append(args, "-v", r.cachevolFor(dir)+":"+dir)
+	}
+	for _, mount := range s.Mounts {
+		args = append(args, "-v", r.hostMount(mount))
+	}
+	env, err := r.envArgs(s)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, env...)
+	args = append(args, r.resourceArgs(s)...)
+	gpus, err := r.gpuArgs(s)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, gpus...)
+	privs, err := r.privilegeArgs(s)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, privs...)
+	user, err := r.userArgs(s, r.platformTag(s, platform))
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, user...)
+	if network := r.network(s); network != "" {
+		args = append(args, "--network", network)
+	}
+	for _, port := range s.Ports {
+		args = append(args, "-p", port)
+	}
+	if r.TTY {
+		args = append(args, "-it")
+	}
+	args = append(args, "-w", "/work", r.platformTag(s, platform))
+	return append(args, r.commandArgs(s)...), nil
+}
+
+// network returns the network the target's container is run in, or empty
+// for the runtime's default network.
+func (r *Runner) network(s *parser.Target) string {
+	switch {
+	case r.servicenet != "" && (r.Offline || s.Network == ""):
+		return r.servicenet
+	case r.Offline:
+		return "none"
+	}
+	return s.Network
+}
+
+// checkMounts returns an error if the target mounts paths of this machine
+// but the daemon is remote.
+func (r *Runner) checkMounts(s *parser.Target) error {
+	if !r.remote {
+		return nil
+	}
+	if r.Host {
+		return fmt.Errorf("host mode cannot be used with a remote daemon")
+	}
+	if len(s.Mounts) > 0 {
+		return fmt.Errorf("MOUNT %s cannot be used with a remote daemon", s.Mounts[0])
+	}
+	return nil
+}
+
+// resourceArgs returns the runtime arguments limiting the resources of the
+// target's container.
+func (r *Runner) resourceArgs(s *parser.Target) []string {
+	args := []string{}
+	for _, limit := range []struct{ flag, value, fallback string }{
+		{"--cpus", s.CPUs, r.CPUs},
+		{"--memory", s.Memory, r.Memory},
+		{"--shm-size", s.ShmSize, r.ShmSize},
+	} {
+		if limit.value == "" {
+			limit.value = limit.fallback
+		}
+		if limit.value != "" {
+			args = append(args, limit.flag, limit.value)
+		}
+	}
+	return args
+}
+
+// privilegeArgs returns the runtime arguments granting the target's
+// container its extra privileges, or an error if they are not allowed.
+func (r *Runner) privilegeArgs(s *parser.Target) ([]string, error) {
+	args := []string{}
+	if s.Privileged {
+		args = append(args, "--privileged")
+	}
+	for _, c := range s.CapAdd {
+		args = append(args, "--cap-add", c)
+	}
+	for _, d := range s.Devices {
+		args = append(args, "--device", d)
+	}
+	if s.DockerSocket != "" {
+		// The socket is on the daemon's machine, which is also where the
+		// volume is resolved, so this works with remote daemons too.
+		args = append(args, "-v", s.DockerSocket+":/var/run/docker.sock")
+	}
+	if len(args) > 0 && !r.AllowPrivileged {
+		return nil, fmt.Errorf("target %s requires extra privileges (PRIVILEGED, CAP_ADD, DEVICE or DOCKER_SOCKET), run with --allow-privileged to permit them", s.Name)
+	}
+	return args, nil
+}
+
+// hostMount converts a MOUNT spec of the form hostpath:containerpath[:ro]
+// into a volume argument, resolving the host path relative to the project.
+func (r *Runner) hostMount(spec string) string {
+	mode := ""
+	if i := strings.LastIndex(spec, ":"); i >= 0 && (spec[i+1:] == "ro" || spec[i+1:] == "rw") {
+		spec, mode = spec[:i], spec[i:]
+	}
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return spec + mode
+	}
+	host := spec[:i]
+	if !filepath.IsAbs(host) {
+		host = filepath.Join(r.Dir, filepath.FromSlash(host))
+	}
+	return host + spec[i:] + mode
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runningPoll is how often a run waiting for another one checks whether it
+// finished.
+const runningPoll = time.Second
+
+// runningPath returns the path of the file marking the project as in use by
+// a run, which holds the PID and host name of its process and when it
+// started. It is separate from the lock file of pinned base images.
+func (r *Runner) runningPath() string {
+	return filepath.Join(r.Dir, ".drmake", "running")
+}
+
+// acquireRun marks the project as in use, so that two runs in the same
+// project do not share its workspace volume at the same time. If another
+// run is in progress, it waits for it to finish with the Wait option, takes
+// over with the Force option, and fails otherwise. A mark left by a process
+// that no longer exists on this machine is taken over.
+func (r *Runner) acquireRun() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(r.runningPath()), 0755); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	mark := fmt.Sprintf("%d %s %s\n", os.Getpid(), host, time.Now().Format(time.RFC3339))
+	release := func() {
+		if data, err := ioutil.ReadFile(r.runningPath()); err == nil && string(data) == mark {
+			os.Remove(r.runningPath())
+		}
+	}
+
+	waiting := false
+	for {
+		if err := r.createRunning(mark); err == nil {
+			return release, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+
+		data, pid, owner, since, ok := r.readRunning()
+		switch {
+		case !ok || (owner == host && !processExists(pid)):
+			log.Printf("Removing stale run marker of process %d\n", pid)
+		case r.Force:
+			log.Printf("Warning: taking over the project from drmake process %d on %s\n", pid, owner)
+		case r.Wait:
+			if !waiting {
+				log.Printf("Waiting for drmake process %d on %s (running since %s) to finish\n", pid, owner, since)
+				waiting = true
+			}
+			if r.Interrupted() {
+				return nil, ErrInterrupted
+			}
+			time.Sleep(runningPoll)
+			continue
+		default:
+			return nil, fmt.Errorf("project is in use by drmake process %d on %s (running since %s), use --wait to wait for it or --force to run anyway", pid, owner, since)
+		}
+		if err := r.removeRunning(data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// createRunning creates the marker with the contents mark, failing with an
+// error satisfying os.IsExist if there already is one. The marker is
+// written to a temporary file first and linked into place, so that other
+// runs never see it empty.
+func (r *Runner) createRunning(mark string) error {
+	tmp := fmt.Sprintf("%s.%d", r.runningPath(), os.Getpid())
+	if err := ioutil.WriteFile(tmp, []byte(mark), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Link(tmp, r.runningPath())
+}
+
+// removeRunning removes the marker if it still holds stale, the contents of
+// a marker that is taken over. Another run may have replaced it since it was
+// read, in which case the new marker is kept.
+func (r *Runner) removeRunning(stale string) error {
+	tmp := fmt.Sprintf("%s.%d.stale", r.runningPath(), os.Getpid())
+	if err := os.Rename(r.runningPath(), tmp); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(tmp)
+	if data, err := ioutil.ReadFile(tmp); err == nil && string(data) != stale {
+		if err := os.Link(tmp, r.runningPath()); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRunning reads the marker of the run in progress, and its PID, host
+// name and start time. ok is false if the marker is unreadable.
+func (r *Runner) readRunning() (data string, pid int, host, since string, ok bool) {
+	b, err := ioutil.ReadFile(r.runningPath())
+	if err != nil {
+		return "", 0, "", "", false
+	}
+	data = string(b)
+	fields := strings.Fields(data)
+	if len(fields) != 3 {
+		return data, 0, "", "", false
+	}
+	pid, err = strconv.Atoi(fields[0])
+	return data, pid, fields[1], fields[2], err == nil
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAcquireRun(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		name   string
+		marker string
+		force  bool
+		err    string
+	}{
+		{"no marker", "", false, ""},
+		{"stale marker", fmt.Sprintf("999999999 %s 2024-01-01T00:00:00Z\n", host), false, ""},
+		{"unreadable marker", "garbage\n", false, ""},
+		{"running here", fmt.Sprintf("%d %s 2024-01-01T00:00:00Z\n", os.Getpid(), host), false, "project is in use by drmake process"},
+		{"running elsewhere", "999999999 elsewhere 2024-01-01T00:00:00Z\n", false, "project is in use by drmake process 999999999 on elsewhere"},
+		{"forced", "999999999 elsewhere 2024-01-01T00:00:00Z\n", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "drmake-running")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			r := &Runner{Options: Options{Dir: dir, Force: tt.force}}
+			if tt.marker != "" {
+				os.MkdirAll(filepath.Join(dir, ".drmake"), 0755)
+				ioutil.WriteFile(r.runningPath(), []byte(tt.marker), 0644)
+			}
+
+			release, err := r.acquireRun()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				if data, _ := ioutil.ReadFile(r.runningPath()); string(data) != tt.marker {
+					t.Errorf("got marker %q, want it kept as %q", data, tt.marker)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, pid, _, _, ok := r.readRunning(); !ok || pid != os.Getpid() {
+				t.Errorf("got marker of process %d, want %d", pid, os.Getpid())
+			}
+			if _, err := r.acquireRun(); err == nil && !tt.force {
+				t.Errorf("acquired the project twice")
+			}
+			release()
+			if _, err := os.Stat(r.runningPath()); !os.IsNotExist(err) {
+				t.Errorf("marker was not removed on release: %v", err)
+			}
+			if files, _ := ioutil.ReadDir(filepath.Dir(r.runningPath())); len(files) != 0 {
+				t.Errorf("got leftover files %v", files)
+			}
+		})
+	}
+}
+
+func TestRemoveRunningKeepsNewMarker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drmake-running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := &Runner{Options: Options{Dir: dir}}
+	os.MkdirAll(filepath.Join(dir, ".drmake"), 0755)
+
+	// Another run replaced the stale marker after it was read.
+	ioutil.WriteFile(r.runningPath(), []byte("2 host new\n"), 0644)
+	if err := r.removeRunning("1 host old\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(r.runningPath()); string(data) != "2 host new\n" {
+		t.Errorf("got marker %q, want the new one kept", data)
+	}
+
+	if err := r.removeRunning("2 host new\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(r.runningPath()); !os.IsNotExist(err) {
+		t.Errorf("stale marker was not removed: %v", err)
+	}
+}
+
+func TestProcessExists(t *testing.T) {
+	if !processExists(os.Getpid()) {
+		t.Errorf("the current process does not exist")
+	}
+	if processExists(999999999) {
+		t.Errorf("process 999999999 exists")
+	}
+}
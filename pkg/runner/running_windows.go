@@ -0,0 +1,29 @@
+package runner
+
+import "syscall"
+
+const (
+	// processQueryLimitedInformation is the PROCESS_QUERY_LIMITED_INFORMATION
+	// access right, which is enough to read the exit code of a process.
+	processQueryLimitedInformation = 0x1000
+
+	// stillActive is the exit code of a process that is still running.
+	stillActive = 259
+)
+
+// processExists returns whether a process with the given PID exists. A
+// process whose handle cannot be opened for lack of rights exists.
+func processExists(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err == syscall.ERROR_ACCESS_DENIED {
+		return true
+	} else if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
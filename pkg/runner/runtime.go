@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Runtime abstracts the container engine CLI that drmake uses to
+// build images, run containers and manage volumes.
+type Runtime interface {
+	// Name returns the name of the runtime binary.
+	Name() string
+
+	// Command returns a command invoking the runtime with args.
+	Command(args ...string) *exec.Cmd
+
+	// BuildCommand returns a command that builds an image tagged tag from a
+	// Dockerfile read from stdin. If platform is not empty the image is built
+	// for that platform. args are extra build flags.
+	BuildCommand(tag, platform string, args ...string) *exec.Cmd
+
+	// Remote returns whether the runtime's daemon runs on another machine,
+	// which cannot mount paths of this one.
+	Remote() bool
+}
+
+// Endpoint selects the daemon that a runtime talks to. The zero value uses
+// the runtime's default, which for docker honors DOCKER_HOST and
+// DOCKER_CONTEXT.
+type Endpoint struct {
+	// Context is the docker context or podman connection to use.
+	Context string
+
+	// Host is the address of the daemon, such as ssh://user@host or
+	// tcp://host:2376.
+	Host string
+}
+
+var runtimes = map[string]cliRuntime{
+	"docker":  {name: "docker", buildx: true, contextFlag: "--context", hostFlag: "--host"},
+	"podman":  {name: "podman", stdinFile: true, contextFlag: "--connection", hostFlag: "--url"},
+	"nerdctl": {name: "nerdctl", stdinFile: true, hostFlag: "--address"},
+}
+
+// cliRuntime is a Runtime backed by a docker compatible CLI.
+type cliRuntime struct {
+	name string
+
+	// stdinFile is set for runtimes that cannot take a bare "-" build context
+	// and instead need the Dockerfile passed as "-f -" with the (empty)
+	// current directory as context.
+	stdinFile bool
+
+	// buildx is set for runtimes that need "buildx build --load" to build
+	// images for a platform other than the host's.
+	buildx bool
+
+	// contextFlag and hostFlag are the global flags that select the
+	// Endpoint, if the runtime supports them.
+	contextFlag string
+	hostFlag    string
+
+	endpoint Endpoint
+}
+
+func (r *cliRuntime) Name() string {
+	return r.name
+}
+
+func (r *cliRuntime) Command(args ...string) *exec.Cmd {
+	global := []string{}
+	if r.endpoint.Context != "" {
+		global = append(global, r.contextFlag, r.endpoint.Context)
+	}
+	if r.endpoint.Host != "" {
+		global = append(global, r.hostFlag, r.endpoint.Host)
+	}
+	return exec.Command(r.name, append(global, args...)...)
+}
+
+func (r *cliRuntime) BuildCommand(tag, platform string, args ...string) *exec.Cmd {
+	bargs := []string{"build", "--rm", "-t", tag}
+	if platform != "" {
+		if r.buildx {
+			bargs = []string{"buildx", "build", "--load", "-t", tag}
+		}
+		bargs = append(bargs, "--platform", platform)
+	}
+	bargs = append(bargs, args...)
+	if r.stdinFile {
+		bargs = append(bargs, "-f", "-", ".")
+	} else {
+		bargs = append(bargs, "-")
+	}
+	return r.Command(bargs...)
+}
+
+func (r *cliRuntime) Remote() bool {
+	host, context := r.endpoint.Host, r.endpoint.Context
+	if r.name == "docker" {
+		if host == "" && context == "" {
+			host, context = os.Getenv("DOCKER_HOST"), os.Getenv("DOCKER_CONTEXT")
+		}
+		if host == "" && context != "" && context != "default" {
+			out, err := r.Command("context", "inspect", "--format", "{{.Endpoints.docker.Host}}", context).Output()
+			if err != nil {
+				return true
+			}
+			host = strings.TrimSpace(string(out))
+		}
+	} else if context != "" {
+		// Podman connections are always to another machine or VM.
+		return true
+	}
+	return host != "" && !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "npipe://")
+}
+
+// NewRuntime returns the runtime backed by the CLI called name, talking to
+// the daemon selected by endpoint.
+func NewRuntime(name string, endpoint Endpoint) (Runtime, error) {
+	r, ok := runtimes[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported runtime: %s", name)
+	}
+	if endpoint.Context != "" && r.contextFlag == "" {
+		return nil, fmt.Errorf("runtime %s does not support contexts", name)
+	}
+	r.endpoint = endpoint
+	return &r, nil
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRuntime is a Runtime that records its commands instead of running
+// them. Each command writes its stdin to a file and prints the output given
+// by respond, if set, exiting with its exit code.
+type fakeRuntime struct {
+	dir     string
+	calls   []fakeCall
+	respond func(args []string) (out string, code int)
+}
+
+// fakeCall is a command of a fakeRuntime.
+type fakeCall struct {
+	args  []string
+	stdin string
+}
+
+// newFakeRuntime returns a fakeRuntime that keeps the stdin of its commands
+// in a temporary directory, which is removed when the test finishes.
+func newFakeRuntime(t *testing.T) (*fakeRuntime, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "drmake-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeRuntime{dir: dir}, func() { os.RemoveAll(dir) }
+}
+
+func (f *fakeRuntime) Name() string { return "docker" }
+
+func (f *fakeRuntime) Command(args ...string) *exec.Cmd {
+	stdin := filepath.Join(f.dir, fmt.Sprint(len(f.calls)))
+	f.calls = append(f.calls, fakeCall{args: args, stdin: stdin})
+	out, code := "", 0
+	if f.respond != nil {
+		out, code = f.respond(args)
+	}
+	return exec.Command("sh", "-c", `cat > "$1"; printf %s "$2"; exit "$3"`, "sh", stdin, out, strconv.Itoa(code))
+}
+
+func (f *fakeRuntime) BuildCommand(tag, platform string, args ...string) *exec.Cmd {
+	return f.Command(append([]string{"build", "-t", tag, "--platform", platform}, args...)...)
+}
+
+func (f *fakeRuntime) Remote() bool { return false }
+
+// commands returns the commands run so far, with their arguments joined by
+// spaces.
+func (f *fakeRuntime) commands() []string {
+	cmds := []string{}
+	for _, c := range f.calls {
+		cmds = append(cmds, strings.Join(c.args, " "))
+	}
+	return cmds
+}
+
+// input returns the stdin of the i-th command.
+func (f *fakeRuntime) input(i int) string {
+	data, _ := ioutil.ReadFile(f.calls[i].stdin)
+	return string(data)
+}
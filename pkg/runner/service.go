@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// Up starts the service targets and leaves them running.
+func (r *Runner) Up(list parser.Targets, services []*parser.Target) error {
+	for _, s := range services {
+		if err := r.RunTarget(list, s, &Result{Target: s.Name}); err != nil {
+			return err
+		}
+	}
+	r.started = nil
+	return nil
+}
+
+// Down stops and removes the containers of the service targets and the
+// project's service network.
+func (r *Runner) Down(services []*parser.Target) {
+	for _, s := range services {
+		if r.serviceRunning(s) {
+			log.Printf("Stopping service %s\n", s.Name)
+		}
+		r.rt.Command("rm", "-f", r.ServiceContainer(s)).Run()
+	}
+	net := "drmake-net-" + r.ProjectID()
+	r.rt.Command("network", "rm", net).Run()
+	r.rt.Command("network", "rm", net+"-offline").Run()
+}
+
+// Logs prints the logs of the service target's container, following them
+// if follow is set.
+func (r *Runner) Logs(s *parser.Target, follow bool) error {
+	args := []string{"logs"}
+	if follow {
+		args = append(args, "-f")
+	}
+	cmd := r.rt.Command(append(args, r.ServiceContainer(s))...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return r.runTracked(cmd, "", "")
+}
+
+// networkName returns the name of the project's service network. Offline
+// runs use a separate internal network, which has no outside access.
+func (r *Runner) networkName() string {
+	if r.Offline {
+		return "drmake-net-" + r.ProjectID() + "-offline"
+	}
+	return "drmake-net-" + r.ProjectID()
+}
+
+// ServiceContainer returns the name of a service target's container. Unlike
+// the containers of other targets, it is stable across runs.
+func (r *Runner) ServiceContainer(s *parser.Target) string {
+	return reContainerName.ReplaceAllString(fmt.Sprintf("%s-%s", r.Image(), s.Name), "_")
+}
+
+// serviceRunning returns whether the service's container is running.
+func (r *Runner) serviceRunning(s *parser.Target) bool {
+	out, err := r.rt.Command("inspect", "-f", "{{.State.Running}}", r.ServiceContainer(s)).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// startService builds the service target's image and starts its container
+// detached, unless it is already running. Other targets reach the service by
+// its target name.
+func (r *Runner) startService(s *parser.Target, dfile string) error {
+	r.servicenet = r.networkName()
+	if r.serviceRunning(s) {
+		log.Printf("Service %s is already running\n", s.Name)
+		return r.waitHealthy(s)
+	}
+
+	platform := r.platforms(s)[0]
+	if err := r.build(s, dfile, platform); err != nil {
+		return &TargetError{Target: s.Name, Op: "build", Err: err}
+	}
+
+	if r.rt.Command("network", "inspect", r.servicenet).Run() != nil {
+		args := []string{"network", "create"}
+		if r.Offline {
+			args = append(args, "--internal")
+		}
+		if err := r.rt.Command(append(args, r.servicenet)...).Run(); err != nil {
+			return &TargetError{Target: s.Name, Op: "network create", Err: err}
+		}
+	}
+
+	// Remove a stopped container left over from an earlier run.
+	r.rt.Command("rm", "-f", r.ServiceContainer(s)).Run()
+
+	log.Printf("Starting service %s\n", s.Name)
+	args := []string{"run", "-d", "--name", r.ServiceContainer(s),
+		"--network", r.servicenet, "--network-alias", s.Name}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	if err := r.checkMounts(s); err != nil {
+		return &TargetError{Target: s.Name, Op: "start", Err: err}
+	}
+	for _, dir := range s.Caches {
+		args = append(args, "-v", r.cachevolFor(dir)+":"+dir)
+	}
+	for _, mount := range s.Mounts {
+		args = append(args, "-v", r.hostMount(mount))
+	}
+	env, err := r.envArgs(s)
+	if err != nil {
+		return &TargetError{Target: s.Name, Op: "start", Err: err}
+	}
+	args = append(args, env...)
+	args = append(args, r.resourceArgs(s)...)
+	gpus, err := r.gpuArgs(s)
+	if err != nil {
+		return &TargetError{Target: s.Name, Op: "start", Err: err}
+	}
+	args = append(args, gpus...)
+	privs, err := r.privilegeArgs(s)
+	if err != nil {
+		return &TargetError{Target: s.Name, Op: "start", Err: err}
+	}
+	args = append(args, privs...)
+	for _, port := range s.Ports {
+		args = append(args, "-p", port)
+	}
+	cmd := r.rt.Command(append(args, r.platformTag(s, platform))...)
+	cmd.Stderr = os.Stderr
+	if err := r.runTracked(cmd, "", ""); err != nil {
+		return &TargetError{Target: s.Name, Op: "start", Err: err}
+	}
+	r.started = append(r.started, s)
+	return r.waitHealthy(s)
+}
+
+// healthTimeout returns how long to wait for the service to become healthy.
+func (r *Runner) healthTimeout(s *parser.Target) time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return r.HealthTimeout
+}
+
+// waitHealthy waits until the service container's HEALTHCHECK reports it
+// healthy. Services without a health check are considered healthy as soon as
+// they start.
+func (r *Runner) waitHealthy(s *parser.Target) error {
+	timeout := r.healthTimeout(s)
+	deadline := time.Now().Add(timeout)
+	logged := false
+	for {
+		out, err := r.rt.Command("inspect", "-f",
+			"{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", r.ServiceContainer(s)).Output()
+		if err != nil {
+			return &TargetError{Target: s.Name, Op: "health check", Err: err}
+		}
+		fields := strings.Fields(string(out))
+		switch {
+		case len(fields) > 0 && fields[0] != "running" && fields[0] != "created":
+			return &TargetError{Target: s.Name, Op: "health check", Err: fmt.Errorf("container is %s", fields[0])}
+		case len(fields) == 1 || (len(fields) > 1 && fields[1] == "healthy"):
+			return nil
+		case len(fields) > 1 && fields[1] == "unhealthy":
+			return &TargetError{Target: s.Name, Op: "health check", Err: fmt.Errorf("container is unhealthy")}
+		}
+
+		if r.Interrupted() {
+			return ErrInterrupted
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return &TargetError{Target: s.Name, Op: "health check", Err: &timeoutError{timeout}}
+		}
+		if !logged {
+			log.Printf("Waiting for service %s to become healthy\n", s.Name)
+			logged = true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// stopServices stops the services started by the current run.
+func (r *Runner) stopServices() {
+	for _, s := range r.started {
+		log.Printf("Stopping service %s\n", s.Name)
+		r.rt.Command("rm", "-f", r.ServiceContainer(s)).Run()
+	}
+	r.started = nil
+}
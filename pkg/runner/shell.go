@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// Shell builds the target's image and runs shell interactively in its
+// container, with everything else set up as when running the target.
+// Dependencies are not run.
+func (r *Runner) Shell(list parser.Targets, s *parser.Target, shell string) error {
+	defer r.enter(s)()
+	dfile, err := s.Dockerfile(list, r.Dir)
+	if err != nil {
+		return err
+	}
+	if s.Phony || (dfile == "" && strings.HasPrefix(s.Image, "#")) {
+		return fmt.Errorf("target %s has no image to run a shell in", s.Name)
+	}
+
+	platform := r.platforms(s)[0]
+	r.prepVolume()
+	if _, err := r.buildOrReuse(list, s, dfile, platform); err != nil {
+		return &TargetError{Target: s.Name, Op: "build", Err: err}
+	}
+
+	// Replace the image's command with the shell, keeping everything else
+	// the target's container would be run with.
+	rargs, err := r.runArgs(s, platform)
+	if err != nil {
+		return err
+	}
+	img := rargs[len(rargs)-1]
+	rargs = rargs[:len(rargs)-1]
+	if !r.TTY {
+		rargs = append(rargs, "-i")
+	}
+	rargs = append(rargs, "--entrypoint", shell, img, "-i")
+
+	cmd := r.rt.Command(rargs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return r.runTracked(cmd, r.containerName(s), "")
+}
@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// sourcesPath is the file that records the input digest of every target with
+// SOURCES at its last successful run. Unlike the run state, it is kept
+// across runs.
+func (r *Runner) sourcesPath() string {
+	return filepath.Join(r.Dir, ".drmake", "sources")
+}
+
+// loadSources reads the recorded digests of targets with SOURCES.
+func (r *Runner) loadSources() runState {
+	return readState(r.sourcesPath())
+}
+
+// saveSources writes the recorded digests of targets with SOURCES.
+func (r *Runner) saveSources(sources runState) error {
+	return writeState(r.sourcesPath(), sources)
+}
+
+// sourceFiles returns the files matched by the target's SOURCES, sorted.
+// SOURCES are paths relative to the project that may contain glob patterns,
+// and directories include every file below them. Paths that match nothing
+// are returned as they are, so that creating them later changes the digest.
+func (r *Runner) sourceFiles(s *parser.Target) []string {
+	files := []string{}
+	seen := map[string]bool{}
+	for _, src := range s.Sources {
+		pattern := filepath.Join(r.Dir, filepath.FromSlash(src))
+		roots, _ := filepath.Glob(pattern)
+		if len(roots) == 0 {
+			roots = []string{pattern}
+		}
+		for _, root := range roots {
+			filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+				if seen[name] {
+					return nil
+				}
+				if err != nil || !info.IsDir() {
+					seen[name] = true
+					files = append(files, name)
+				}
+				return nil
+			})
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// upToDate returns whether the target can be skipped like an up to date make
+// target: it declares SOURCES, its inputs have the digest they had when it
+// last succeeded, none of its dependencies changed in this run and its
+// ARTIFACT files still exist.
+func (r *Runner) upToDate(s *parser.Target, sources runState, digest string, changed map[string]bool) bool {
+	defer r.enter(s)()
+	if len(s.Sources) == 0 || s.Service || r.Fresh || r.AlwaysMake || digest == "" || sources[s.Name] != digest {
+		return false
+	}
+	if changedDep(s, changed) {
+		return false
+	}
+	return r.artifactsExist(s)
+}
+
+// artifactsExist returns whether the artifacts of the target are still in
+// the project directory, so that skipping it does not lose them. Uploaded
+// artifacts are assumed to exist, and artifacts streamed to stdout never do.
+func (r *Runner) artifactsExist(s *parser.Target) bool {
+	for _, a := range s.Artifacts {
+		if a.Dst == "-" {
+			return false
+		}
+		if IsUpload(a.Dst) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(r.Dir, filepath.FromSlash(a.Dst))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetSources removes the recorded digest of a target that failed, so that
+// it is not skipped until it succeeds again.
+func (r *Runner) forgetSources(sources runState, s *parser.Target) {
+	if _, ok := sources[s.Name]; ok {
+		delete(sources, s.Name)
+		r.saveSources(sources)
+	}
+}
+
+// changedDep returns whether a dependency of the target is in changed.
+func changedDep(s *parser.Target, changed map[string]bool) bool {
+	for _, dep := range s.Deps {
+		if changed[dep] {
+			return true
+		}
+	}
+	return false
+}
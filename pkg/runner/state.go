@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// runState records the input digest of every target that succeeded in the
+// current (or, with Resume, the previous) run, so that a failed run can be
+// resumed from the first target that did not succeed.
+type runState map[string]string
+
+func (r *Runner) statePath() string {
+	return filepath.Join(r.Dir, ".drmake", "state")
+}
+
+// loadState reads the run state. A missing or unreadable file yields an
+// empty state.
+func (r *Runner) loadState() runState {
+	return readState(r.statePath())
+}
+
+// saveState writes the run state.
+func (r *Runner) saveState(state runState) error {
+	return writeState(r.statePath(), state)
+}
+
+// readState reads a file of target names and digests. A missing or
+// unreadable file yields an empty state.
+func readState(name string) runState {
+	state := runState{}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return state
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			state[fields[0]] = fields[1]
+		}
+	}
+	return state
+}
+
+// writeState writes a file of target names and digests.
+func writeState(name string, state runState) error {
+	names := []string{}
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := ""
+	for _, name := range names {
+		data += fmt.Sprintf("%s %s\n", name, state[name])
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, []byte(data), 0644)
+}
+
+// clearState removes the run state after a successful run.
+func (r *Runner) clearState() {
+	os.Remove(r.statePath())
+}
+
+// inputDigest returns the digest of the target's inputs, or an empty string
+// if its Dockerfile cannot be generated.
+func (r *Runner) inputDigest(list parser.Targets, s *parser.Target) string {
+	defer r.enter(s)()
+	dfile, err := s.Dockerfile(list, r.Dir)
+	if err != nil {
+		return ""
+	}
+	return r.digest(list, s, dfile)
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// enter switches the runner to the project directory of the target, which
+// differs from the main project directory for targets declared in SUBDIR
+// build files, and returns a function that switches back. While in a SUBDIR
+// project, relative paths are resolved from its directory and it has its
+// own workspace and cache volumes.
+func (r *Runner) enter(s *parser.Target) func() {
+	dir := s.Dir
+	if dir == "" {
+		dir = r.rootDir()
+	}
+	if dir == r.Dir {
+		return func() {}
+	}
+	prevDir, prevRoot := r.Dir, r.root
+	r.root = r.rootDir()
+	r.Dir = dir
+	if r.Dir == r.root {
+		r.root = ""
+	}
+	return func() { r.Dir, r.root = prevDir, prevRoot }
+}
+
+// rootDir returns the main project directory.
+func (r *Runner) rootDir() string {
+	if r.root != "" {
+		return r.root
+	}
+	return r.Dir
+}
+
+// subdirSuffix returns the suffix of the volume names of the SUBDIR project
+// the runner is in, or an empty string in the main project.
+func (r *Runner) subdirSuffix() string {
+	if r.root == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(r.root, r.Dir)
+	if err != nil {
+		rel = r.Dir
+	}
+	return fmt.Sprintf("-%.6x", sha1.Sum([]byte(filepath.ToSlash(rel))))
+}
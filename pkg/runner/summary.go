@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Result records the outcome of running a target for the build summary.
+type Result struct {
+	Target    string
+	Status    string
+	Build     time.Duration
+	Run       time.Duration
+	Artifacts []string
+
+	// Cached is set if the target was skipped as unchanged or its image
+	// was reused from the image cache.
+	Cached bool
+
+	// Files are the files copied out as the target's artifacts.
+	Files []ArtifactFile
+
+	// AllowedFailure is set if the target failed but is marked
+	// ALLOW_FAILURE, so the run did not fail.
+	AllowedFailure bool
+
+	// Error is the error the target failed with, and Output the end of the
+	// output of its build and container when the Report option is set.
+	Error  string
+	Output string
+}
+
+// PrintSummary writes a table of target results to w. Targets that were
+// never run are reported as skipped.
+func PrintSummary(w io.Writer, results []*Result) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTATUS\tBUILD\tRUN\tARTIFACTS")
+	for _, res := range results {
+		status := res.Status
+		if status == "" {
+			status = "skipped"
+		} else if res.AllowedFailure {
+			status = "failed (allowed)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", res.Target, status,
+			formatDuration(res.Build), formatDuration(res.Run), strings.Join(res.Artifacts, " "))
+	}
+	tw.Flush()
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
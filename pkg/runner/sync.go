@@ -0,0 +1,203 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// syncState records the size, modification time and mode of every file and
+// directory of the project directory when it was last copied into the
+// workspace volume, keyed by slash separated path.
+type syncState map[string]string
+
+// syncPath returns the path of the sync state of the workspace volume.
+func (r *Runner) syncPath() string {
+	return filepath.Join(r.Dir, ".drmake", "sync", r.wsvol())
+}
+
+// loadSync reads the sync state. A missing or unreadable file yields an
+// empty state, so that everything is copied.
+func (r *Runner) loadSync() syncState {
+	state := syncState{}
+	data, err := ioutil.ReadFile(r.syncPath())
+	if err != nil {
+		return state
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.SplitN(line, " ", 4); len(fields) == 4 {
+			state[fields[3]] = strings.Join(fields[:3], " ")
+		}
+	}
+	return state
+}
+
+// saveSync writes the sync state.
+func (r *Runner) saveSync(state syncState) error {
+	data := ""
+	for _, rel := range state.paths() {
+		data += state[rel] + " " + rel + "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(r.syncPath()), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.syncPath(), []byte(data), 0644)
+}
+
+// paths returns the paths of the state in sorted order, so that
+// directories come before their contents.
+func (s syncState) paths() []string {
+	paths := []string{}
+	for rel := range s {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// scanProject returns the current sync state of the project directory,
+// leaving out ignored paths and drmake's own .drmake directory, which
+// changes with every run.
+func (r *Runner) scanProject() (syncState, error) {
+	ignore := LoadIgnore(r.Dir)
+	state := syncState{}
+	err := filepath.Walk(r.Dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(r.Dir, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() && (rel == ".drmake" || ignore.MatchDir(rel)) {
+			return filepath.SkipDir
+		} else if !info.IsDir() && ignore.Match(rel) {
+			return nil
+		}
+		state[rel] = fmt.Sprintf("%d %d %o", info.Size(), info.ModTime().UnixNano(), info.Mode())
+		return nil
+	})
+	return state, err
+}
+
+// syncToVolume copies the files of the project directory that changed
+// since the last sync into the workspace volume, and removes the ones that
+// were deleted. If full is set, or there is no record of the last sync,
+// everything is copied. Files created in the volume by targets are left
+// alone.
+func (r *Runner) syncToVolume(full bool) error {
+	current, err := r.scanProject()
+	if err != nil {
+		return err
+	}
+	last := syncState{}
+	if !full {
+		last = r.loadSync()
+	}
+
+	changed := []string{}
+	for _, rel := range current.paths() {
+		if last[rel] != current[rel] {
+			changed = append(changed, rel)
+		}
+	}
+	removed := []string{}
+	for _, rel := range last.paths() {
+		if _, ok := current[rel]; ok {
+			continue
+		}
+		// Removing a directory removes its contents too.
+		if n := len(removed); n > 0 && strings.HasPrefix(rel, removed[n-1]+"/") {
+			continue
+		}
+		removed = append(removed, rel)
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	if len(last) == 0 {
+		log.Printf("Copying data: %s -> /work\n", r.Dir)
+	} else {
+		log.Printf("Syncing data: %s -> /work (%d changed, %d removed)\n", r.Dir, len(changed), len(removed))
+	}
+	if r.Verbose {
+		for _, rel := range removed {
+			log.Printf("Removed: %s\n", rel)
+		}
+		for _, rel := range changed {
+			log.Printf("Changed: %s\n", rel)
+		}
+	}
+	if err := r.removeFromVolume(removed); err != nil {
+		return err
+	}
+	if err := r.copyToVolume(changed); err != nil {
+		return err
+	}
+	return r.saveSync(current)
+}
+
+// syncBack copies the path (or glob) p of the workspace volume back to the
+// same path in the project directory, replacing the files there, using a
+// helper container created from image.
+func (r *Runner) syncBack(p, image string) error {
+	src := path.Clean(p)
+	a := parser.Artifact{Src: src, Dst: path.Dir(src) + "/"}
+	if isGlob(src) {
+		a.Dst = globRoot(src) + "/"
+	}
+	_, err := r.copyArtifactTo(a, image, filepath.Join(r.Dir, filepath.FromSlash(a.Dst)))
+	return err
+}
+
+// copyToVolume copies the files and directories at the paths, relative to
+// the project directory, into the workspace volume.
+func (r *Runner) copyToVolume(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	id, err := r.createHelper(r.HelperImage)
+	if err != nil {
+		return err
+	}
+	defer r.removeHelper(id)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTarPaths(pw, r.Dir, paths))
+	}()
+	cmd := r.rt.Command("cp", "-", id+":/work")
+	cmd.Stdin = pr
+	cmd.Stdout = r.stdout()
+	cmd.Stderr = os.Stderr
+	err = r.runTracked(cmd, "", "")
+	pr.Close()
+	return err
+}
+
+// removeFromVolume removes the paths, relative to /work, from the
+// workspace volume.
+func (r *Runner) removeFromVolume(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if err := r.checkHelper(); err != nil {
+		return err
+	}
+	cmd := r.rt.Command("run", "--rm", "-i", "-v", r.wsvol()+":/work", "-w", "/work",
+		r.HelperImage, "xargs", "-0", "rm", "-rf", "--")
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00"))
+	cmd.Stdout = r.stdout()
+	cmd.Stderr = os.Stderr
+	return r.runTracked(cmd, "", "")
+}
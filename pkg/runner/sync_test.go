@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemoveFromVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		check   string
+		code    int
+		removed string
+		err     string
+	}{
+		{"nothing removed", nil, "", 0, "", ""},
+		{"removed", []string{"a.txt", "dir/b c.txt"}, "", 0, "a.txt\x00dir/b c.txt", ""},
+		{"missing tools", []string{"a.txt"}, "tar\nxargs\n", 0, "", "helper image alpine has no tar, xargs"},
+		{"no shell", []string{"a.txt"}, "", 127, "", "helper image alpine cannot run sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, cleanup := newFakeRuntime(t)
+			defer cleanup()
+			rt.respond = func(args []string) (string, int) {
+				if strings.Contains(strings.Join(args, " "), "--entrypoint sh") {
+					return tt.check, tt.code
+				}
+				return "", 0
+			}
+			r := New(rt, Options{Dir: rt.dir, HelperImage: "alpine"})
+
+			err := r.removeFromVolume(tt.paths)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				if len(rt.calls) != 1 {
+					t.Errorf("got commands %q, want only the helper check", rt.commands())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.removed == "" {
+				if len(rt.calls) != 0 {
+					t.Errorf("got commands %q, want none", rt.commands())
+				}
+				return
+			}
+			cmds := rt.commands()
+			if len(cmds) != 2 || !strings.HasSuffix(cmds[1], "alpine xargs -0 rm -rf --") {
+				t.Fatalf("got commands %q, want a helper check and rm", cmds)
+			}
+			if got := rt.input(1); got != tt.removed {
+				t.Errorf("got removed paths %q, want %q", got, tt.removed)
+			}
+
+			// The helper image is only checked once.
+			if err := r.removeFromVolume(tt.paths); err != nil || len(rt.calls) != 3 {
+				t.Errorf("got error %v and commands %q, want a single rm", err, rt.commands()[2:])
+			}
+		})
+	}
+}
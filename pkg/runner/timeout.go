@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// ExitTimeout is the exit code used when a target times out, matching
+// timeout(1).
+const ExitTimeout = 124
+
+// timeoutError is returned when a target's container runs longer than its
+// timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.timeout)
+}
+
+func (e *timeoutError) ExitCode() int {
+	return ExitTimeout
+}
+
+// runTimeout returns the maximum run time of the target's container, or 0
+// for no limit.
+func (r *Runner) runTimeout(s *parser.Target) time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return r.Timeout
+}
+
+// runWithTimeout runs cmd like runTracked, but removes container once
+// timeout has passed.
+func (r *Runner) runWithTimeout(cmd *exec.Cmd, container, image string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return r.runTracked(cmd, container, image)
+	}
+
+	var expired int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		log.Printf("Timed out after %v, stopping %s\n", timeout, container)
+		r.rt.Command("rm", "-f", container).Run()
+	})
+	err := r.runTracked(cmd, container, image)
+	timer.Stop()
+	if atomic.LoadInt32(&expired) == 1 {
+		return &timeoutError{timeout: timeout}
+	}
+	return err
+}
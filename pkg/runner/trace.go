@@ -0,0 +1,206 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpSpan is a span in the OTLP JSON encoding.
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// tracer turns the events of a run into a trace, which is exported to an
+// OTLP/HTTP endpoint when the run finishes. The run is the root span, with
+// a span for parsing the build file and one for each target, which in turn
+// has spans for building its image, running its container and copying its
+// artifacts.
+type tracer struct {
+	endpoint string
+	traceID  string
+	root     otlpSpan
+	spans    []otlpSpan
+	targets  map[string]*otlpSpan
+
+	// parse is the span of parsing the build file, which happens before
+	// the run starts.
+	parse *otlpSpan
+}
+
+// RecordParse records that parsing the build file started at start and
+// failed with err, if not nil, for the trace of the next run.
+func (r *Runner) RecordParse(start time.Time, err error) {
+	if r.tracer == nil {
+		return
+	}
+	span := newSpan("parse", start, time.Now(), err)
+	r.tracer.parse = &span
+}
+
+func newSpanID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func newSpan(name string, start, end time.Time, err error) otlpSpan {
+	span := otlpSpan{SpanID: newSpanID(8), Name: name, Kind: 1, Start: unixNano(start), End: unixNano(end)}
+	if err != nil {
+		span.Status = otlpStatus{Code: 2, Message: err.Error()}
+	}
+	return span
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+// event adds the span ended by e to the trace, and exports the trace when
+// the run finishes.
+func (t *tracer) event(e Event) {
+	var err error
+	if e.Error != "" {
+		err = fmt.Errorf("%s", e.Error)
+	}
+	start := e.Time.Add(-time.Duration(e.Duration * float64(time.Second)))
+	switch e.Type {
+	case "run_started":
+		t.traceID = newSpanID(16)
+		t.root = newSpan("drmake", e.Time, e.Time, nil)
+		t.root.Attributes = []otlpAttribute{stringAttribute("drmake.targets", strings.Join(e.Targets, " "))}
+		t.spans = nil
+		t.targets = map[string]*otlpSpan{}
+		if t.parse != nil {
+			t.root.Start = t.parse.Start
+			t.add(*t.parse, &t.root)
+			t.parse = nil
+		}
+	case "target_started":
+		span := newSpan("target "+e.Target, e.Time, e.Time, nil)
+		span.Attributes = []otlpAttribute{stringAttribute("drmake.target", e.Target)}
+		t.targets[e.Target] = &span
+	case "build_finished", "container_finished", "artifact_copied":
+		name := map[string]string{"build_finished": "build", "container_finished": "run", "artifact_copied": "artifact"}[e.Type]
+		span := newSpan(name+" "+e.Target, start, e.Time, err)
+		span.Attributes = []otlpAttribute{stringAttribute("drmake.target", e.Target)}
+		if e.Platform != "" {
+			span.Attributes = append(span.Attributes, stringAttribute("drmake.platform", e.Platform))
+		}
+		if e.ExitCode != nil {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: "drmake.exit_code", Value: map[string]string{"intValue": strconv.Itoa(*e.ExitCode)}})
+		}
+		if e.Src != "" {
+			span.Attributes = append(span.Attributes, stringAttribute("drmake.artifact.src", e.Src), stringAttribute("drmake.artifact.dst", e.Dst))
+		}
+		t.add(span, t.targets[e.Target])
+	case "target_finished":
+		span, ok := t.targets[e.Target]
+		if !ok {
+			s := newSpan("target "+e.Target, e.Time, e.Time, nil)
+			span = &s
+		}
+		span.End = unixNano(e.Time)
+		if err != nil {
+			span.Status = otlpStatus{Code: 2, Message: e.Error}
+		}
+		span.Attributes = append(span.Attributes, stringAttribute("drmake.status", e.Status))
+		t.add(*span, &t.root)
+		delete(t.targets, e.Target)
+	case "run_finished":
+		t.root.End = unixNano(e.Time)
+		if err != nil {
+			t.root.Status = otlpStatus{Code: 2, Message: e.Error}
+		}
+		t.add(t.root, nil)
+		if err := t.export(); err != nil {
+			log.Printf("Failed to export trace to %s: %v\n", t.endpoint, err)
+		}
+	}
+}
+
+// add adds span to the trace as a child of parent, or as the root span if
+// parent is nil.
+func (t *tracer) add(span otlpSpan, parent *otlpSpan) {
+	span.TraceID = t.traceID
+	if parent != nil {
+		span.ParentSpanID = parent.SpanID
+	}
+	t.spans = append(t.spans, span)
+}
+
+// export sends the spans of the trace to the endpoint's /v1/traces, with
+// the headers in OTEL_EXPORTER_OTLP_HEADERS.
+func (t *tracer) export() error {
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{stringAttribute("service.name", "drmake")},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "drmake"},
+				"spans": t.spans,
+			}},
+		}},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(t.endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if kv := strings.SplitN(header, "=", 2); len(kv) == 2 {
+			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+var reContainerName = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// inflight tracks the command, container and image that are currently being
+// built or run so they can be cleaned up when the run is interrupted.
+type inflight struct {
+	sync.Mutex
+	interrupted bool
+	cmd         *exec.Cmd
+	container   string
+	image       string
+}
+
+// Interrupt stops the in-flight container and command. The interrupted
+// command then fails with ErrInterrupted, as does every later one.
+func (r *Runner) Interrupt() {
+	r.inflight.Lock()
+	defer r.inflight.Unlock()
+	r.inflight.interrupted = true
+	if r.inflight.container != "" {
+		r.rt.Command("rm", "-f", r.inflight.container).Run()
+	}
+	if r.inflight.cmd != nil && r.inflight.cmd.Process != nil {
+		r.inflight.cmd.Process.Kill()
+	}
+}
+
+// Interrupted returns whether Interrupt was called.
+func (r *Runner) Interrupted() bool {
+	r.inflight.Lock()
+	defer r.inflight.Unlock()
+	return r.inflight.interrupted
+}
+
+// runTracked runs cmd, recording it along with the container name and image
+// tag it operates on so that they can be cleaned up if interrupted.
+func (r *Runner) runTracked(cmd *exec.Cmd, container, image string) error {
+	if r.Verbose {
+		log.Printf("+ %s\n", strings.Join(cmd.Args, " "))
+	}
+	r.inflight.Lock()
+	if r.inflight.interrupted {
+		r.inflight.Unlock()
+		return ErrInterrupted
+	}
+	if err := cmd.Start(); err != nil {
+		r.inflight.Unlock()
+		return err
+	}
+	r.inflight.cmd, r.inflight.container, r.inflight.image = cmd, container, image
+	r.inflight.Unlock()
+
+	err := cmd.Wait()
+
+	r.inflight.Lock()
+	defer r.inflight.Unlock()
+	r.inflight.cmd, r.inflight.container = nil, ""
+	if r.inflight.interrupted {
+		if r.RemoveInterrupted && r.inflight.image != "" {
+			log.Printf("Removing interrupted image %s\n", r.inflight.image)
+			r.rt.Command("rmi", "-f", r.inflight.image).Run()
+		}
+		r.inflight.image = ""
+		return ErrInterrupted
+	}
+	r.inflight.image = ""
+	return err
+}
+
+// containerName returns a unique name for the run container of a target.
+func (r *Runner) containerName(t *parser.Target) string {
+	return reContainerName.ReplaceAllString(fmt.Sprintf("%s-%s-%d", r.Image(), t.Name, os.Getpid()), "_")
+}
@@ -0,0 +1,264 @@
+package runner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// IsUpload returns whether an artifact destination is a URL the artifact
+// is uploaded to rather than a path in the project directory.
+func IsUpload(dst string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "http://", "https://"} {
+		if strings.HasPrefix(dst, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadArtifact copies an artifact out of the workspace volume into a
+// temporary directory and uploads every file in it to the artifact's
+// destination URL. A single file is uploaded to the URL itself, unless it
+// ends with a slash; the files of a directory are uploaded below it, as
+// cp -R would copy them.
+func (r *Runner) uploadArtifact(a parser.Artifact, image string, res *Result) error {
+	tmp, err := ioutil.TempDir("", "drmake-upload")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	local := a
+	local.Dst = "upload/"
+	if _, err := r.copyArtifactTo(local, image, filepath.Join(tmp, "upload")+string(filepath.Separator)); err != nil {
+		return err
+	}
+
+	root := filepath.Join(tmp, "upload")
+	files := []string{}
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, name)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("artifact not found")
+	}
+
+	for _, rel := range files {
+		dst := a.Dst
+		switch {
+		case len(files) == 1 && !strings.Contains(rel, "/") && !strings.HasSuffix(dst, "/") && !isGlob(a.Src):
+			// A single file is uploaded to the URL itself.
+		case strings.HasSuffix(dst, "/") || isGlob(a.Src):
+			dst = strings.TrimSuffix(dst, "/") + "/" + rel
+		default:
+			// Like cp -R, a directory copied to a new path becomes it.
+			dst += "/" + rel[strings.Index(rel, "/")+1:]
+		}
+		log.Printf("Uploading %s to %s\n", rel, redactURL(dst))
+		file := filepath.Join(root, filepath.FromSlash(rel))
+		if err := upload(file, dst); err != nil {
+			return fmt.Errorf("upload to %s failed: %v", redactURL(dst), err)
+		}
+		if err := r.recordArtifact(res, file, redactURL(dst)); err != nil {
+			return err
+		}
+		if r.Checksums {
+			if err := upload(file+".sha256", dst+".sha256"); err != nil {
+				return fmt.Errorf("upload to %s failed: %v", redactURL(dst)+".sha256", err)
+			}
+		}
+	}
+	return nil
+}
+
+// redactURL removes the password from a URL so that it can be logged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// upload uploads the file at name to dst, an s3://, gs://, http:// or
+// https:// URL. Credentials are taken from the environment.
+func upload(name, dst string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	switch {
+	case strings.HasPrefix(dst, "s3://"):
+		req, err = s3Request(dst)
+	case strings.HasPrefix(dst, "gs://"):
+		req, err = gcsRequest(dst)
+	default:
+		req, err = http.NewRequest("PUT", dst, nil)
+	}
+	if err != nil {
+		return err
+	}
+	req.Body = f
+	req.ContentLength = info.Size()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// bucketKey splits a bucket URL such as s3://bucket/key into the bucket and
+// the key.
+func bucketKey(dst string) (string, string, error) {
+	rest := dst[strings.Index(dst, "://")+3:]
+	i := strings.Index(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", fmt.Errorf("%s has no bucket or object name", dst)
+	}
+	return rest[:i], rest[i+1:], nil
+}
+
+// escapePath escapes an object key for use in a URL path, keeping slashes.
+func escapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(part), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// gcsRequest returns an upload request for a gs:// URL. The access token is
+// taken from GOOGLE_OAUTH_ACCESS_TOKEN, or else from gcloud.
+func gcsRequest(dst string) (*http.Request, error) {
+	bucket, key, err := bucketKey(dst)
+	if err != nil {
+		return nil, err
+	}
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+		if err != nil {
+			return nil, fmt.Errorf("no GCS credentials, set GOOGLE_OAUTH_ACCESS_TOKEN or log in with gcloud")
+		}
+		token = strings.TrimSpace(string(out))
+	}
+	req, err := http.NewRequest("PUT", "https://storage.googleapis.com/"+bucket+"/"+escapePath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// s3Request returns an upload request for an s3:// URL, signed with AWS
+// Signature Version 4 using the credentials in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. AWS_REGION selects the
+// region and AWS_ENDPOINT_URL an S3 compatible service.
+func s3Request(dst string) (*http.Request, error) {
+	bucket, key, err := bucketKey(dst)
+	if err != nil {
+		return nil, err
+	}
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil, fmt.Errorf("no S3 credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := "https://" + bucket + ".s3." + region + ".amazonaws.com"
+	uri := "/" + escapePath(key)
+	if custom := os.Getenv("AWS_ENDPOINT_URL"); custom != "" {
+		endpoint = strings.TrimSuffix(custom, "/")
+		uri = "/" + bucket + uri
+	}
+	req, err := http.NewRequest("PUT", endpoint+uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The path is sent as escaped here, which is what is signed.
+	req.URL.RawPath = uri
+
+	now := time.Now().UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           stamp,
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		headers["x-amz-security-token"] = token
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonical := "PUT\n" + uri + "\n\n"
+	for _, name := range names {
+		canonical += name + ":" + headers[name] + "\n"
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signed := strings.Join(names, ";")
+	canonical += "\n" + signed + "\nUNSIGNED-PAYLOAD"
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%x", stamp, scope, sha256.Sum256([]byte(canonical)))
+	sigKey := []byte("AWS4" + secret)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		sigKey = hmacSHA256(sigKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		id, scope, signed, hmacSHA256(sigKey, toSign)))
+	return req, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
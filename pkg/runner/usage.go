@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// Use records when a volume or target image of a project was last used,
+// since the runtime does not track it.
+type Use struct {
+	// Time is when the resource was last used.
+	Time time.Time
+
+	// Dir is the project directory it belongs to.
+	Dir string
+}
+
+// Usage maps volume names and image repositories to their last use, for
+// all projects of the user.
+type Usage map[string]Use
+
+// usagePath returns the path of the usage record, which is shared by all
+// projects so that resources of every project can be reported.
+func usagePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "drmake", "usage"), nil
+}
+
+// LoadUsage reads the usage record. A missing file yields an empty Usage.
+func LoadUsage() (Usage, error) {
+	usage := Usage{}
+	name, err := usagePath()
+	if err != nil {
+		return usage, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return usage, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		usage[fields[0]] = Use{Time: time.Unix(sec, 0), Dir: fields[2]}
+	}
+	return usage, nil
+}
+
+// Save writes the usage record.
+func (u Usage) Save() error {
+	name, err := usagePath()
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for n := range u {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	data := ""
+	for _, n := range names {
+		data += fmt.Sprintf("%s %d %s\n", n, u[n].Time.Unix(), u[n].Dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, []byte(data), 0644)
+}
+
+// markUsed records the volumes and the image of the target as used now.
+// Phony targets use nothing.
+func (r *Runner) markUsed(s *parser.Target) {
+	if s.Phony {
+		return
+	}
+	defer r.enter(s)()
+	if r.used == nil {
+		r.used = map[string]bool{}
+	}
+	if !r.Host {
+		r.used[r.VolumeName("ws")] = true
+	}
+	r.used[r.cachevol()] = true
+	for _, dir := range s.Caches {
+		r.used[r.cachevolFor(dir)] = true
+	}
+	r.used[r.Tag(s)] = true
+}
+
+// saveUsage adds the resources used by the run to the usage record.
+func (r *Runner) saveUsage() error {
+	if len(r.used) == 0 {
+		return nil
+	}
+	usage, err := LoadUsage()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for name := range r.used {
+		usage[name] = Use{Time: now, Dir: r.Dir}
+	}
+	return usage.Save()
+}
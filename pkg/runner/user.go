@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// userArgs returns the runtime arguments running the target's container as
+// the invoking user, if the User option or the target's USER_MAP asks for
+// it. image is the image the container is run from.
+//
+// This only applies in host mode. The workspace volume is owned by root, and
+// artifacts copied out of it are owned by the invoking user regardless.
+func (r *Runner) userArgs(s *parser.Target, image string) ([]string, error) {
+	if !r.Host || (!r.User && !s.UserMap) {
+		return nil, nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid < 0 {
+		return nil, fmt.Errorf("running target %s as the invoking user is not supported on this platform", s.Name)
+	}
+	args := []string{"--user", fmt.Sprintf("%d:%d", uid, gid), "-e", "HOME=/tmp"}
+
+	// The passwd file is mounted from this machine, which a remote daemon
+	// cannot do. Most programs cope without an entry for the user anyway.
+	if r.remote {
+		return args, nil
+	}
+	passwd, err := r.passwdFile(s, image, uid, gid)
+	if err != nil {
+		return nil, err
+	}
+	if passwd != "" {
+		args = append(args, "-v", passwd+":/etc/passwd:ro")
+	}
+	return args, nil
+}
+
+// passwdFile returns the path of a copy of the image's /etc/passwd with an
+// entry added for uid, or an empty string if the image already has one.
+func (r *Runner) passwdFile(s *parser.Target, image string, uid, gid int) (string, error) {
+	data, err := r.imageFile(image, "/etc/passwd")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Split(line, ":"); len(fields) > 2 && fields[2] == strconv.Itoa(uid) {
+			return "", nil
+		}
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, fmt.Sprintf("drmake:x:%d:%d:drmake:/tmp:/bin/sh\n", uid, gid)...)
+
+	name := filepath.Join(r.Dir, ".drmake", "passwd", reContainerName.ReplaceAllString(s.Name, "_"))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// imageFile returns the contents of the file at name in image, or nothing if
+// the image has no such file.
+func (r *Runner) imageFile(image, name string) ([]byte, error) {
+	id, err := r.createHelper(image)
+	if err != nil {
+		return nil, err
+	}
+	defer r.removeHelper(id)
+
+	out, err := r.rt.Command("cp", id+":"+name, "-").Output()
+	if err != nil {
+		return nil, nil
+	}
+	tr := tar.NewReader(bytes.NewReader(out))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package runner
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+func (r *Runner) prepVolume() {
+	if r.Host {
+		return
+	}
+
+	r.warnLegacyVolumes()
+
+	vols := []string{r.wsvol(), r.cachevol()}
+
+	for _, vol := range vols {
+		if r.Fresh {
+			cmd := r.rt.Command("volume", "rm", "-f", vol)
+			cmd.Stdout = r.stdout()
+			cmd.Stderr = os.Stderr
+			cmd.Run()
+		}
+	}
+
+	// A new volume needs everything copied, whatever was synced before.
+	full := r.Fresh || r.rt.Command("volume", "inspect", r.wsvol()).Run() != nil
+	cmd := r.rt.Command("volume", "create", r.wsvol())
+	if err := cmd.Run(); err == nil {
+		if err := r.syncToVolume(full); err != nil {
+			log.Printf("Failed to copy %s to workspace volume: %v\n", r.Dir, err)
+		}
+	}
+}
+
+// warnLegacyVolumes points out volumes left behind by older versions of
+// drmake, which named them after the build file only and so shared them
+// between projects.
+func (r *Runner) warnLegacyVolumes() {
+	if r.VolumePrefix != "" || r.root != "" {
+		return
+	}
+	for _, kind := range []string{"ws", "cache"} {
+		old := r.LegacyVolumeName(kind)
+		if r.rt.Command("volume", "inspect", old).Run() == nil {
+			log.Printf("Volume %s from an older drmake is no longer used, remove it with: %s volume rm %s\n",
+				old, r.rt.Name(), old)
+		}
+	}
+}
+
+// createHelper creates (but does not start) a container from image with the
+// workspace volume mounted at /work, so that files can be streamed in and
+// out of the volume with "cp". Since the container never runs, any image
+// works, including the target's own image.
+func (r *Runner) createHelper(image string) (string, error) {
+	out, err := r.rt.Command("create", "-v", r.wsvol()+":/work", image, "true").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to create helper container from %s: %v", image, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// helperTools are the commands that containers of the helper image run to
+// change the workspace volume in place: to delete the files removed from the
+// project and to copy the volume for ISOLATE.
+var helperTools = []string{"cp", "rm", "tar", "xargs"}
+
+// checkHelper returns an error if containers of the helper image cannot run
+// a shell and helperTools. The image is only checked once.
+func (r *Runner) checkHelper() error {
+	r.helperOnce.Do(func() {
+		script := `for t in "$@"; do command -v "$t" >/dev/null || echo "$t"; done`
+		out, err := r.rt.Command(append([]string{"run", "--rm", "--entrypoint", "sh", r.HelperImage, "-c", script, "sh"}, helperTools...)...).Output()
+		if missing := strings.Fields(string(out)); err != nil {
+			r.helperErr = fmt.Errorf("helper image %s cannot run sh, choose an image with sh, %s with --helper-image: %v", r.HelperImage, strings.Join(helperTools, ", "), err)
+		} else if len(missing) > 0 {
+			r.helperErr = fmt.Errorf("helper image %s has no %s, choose an image with sh, %s with --helper-image", r.HelperImage, strings.Join(missing, ", "), strings.Join(helperTools, ", "))
+		}
+	})
+	return r.helperErr
+}
+
+func (r *Runner) removeHelper(id string) {
+	r.rt.Command("rm", "-f", id).Run()
+}
+
+// writeTar writes the contents of dir to w as a tar archive with paths
+// relative to dir. If prefix is set, paths start with it and dir itself (which
+// may also be a file) is included as prefix, as docker cp would archive it.
+func writeTar(w io.Writer, dir, prefix string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || (rel == "." && prefix == "") {
+			return err
+		}
+		return writeTarEntry(tw, name, path.Join(prefix, filepath.ToSlash(rel)), info)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeTarPaths writes the files and directories at the slash separated
+// paths, relative to dir, to w as a tar archive. The contents of directories
+// are not included unless listed.
+func writeTarPaths(w io.Writer, dir string, paths []string) error {
+	tw := tar.NewWriter(w)
+	for _, rel := range paths {
+		name := filepath.Join(dir, filepath.FromSlash(rel))
+		info, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			// Removed since it was listed.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := writeTarEntry(tw, name, rel, info); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeTarEntry writes the file at name to tw as entry.
+func writeTarEntry(tw *tar.Writer, name, entry string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(name); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = entry
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// copyArtifact copies an artifact out of the workspace volume into the
+// project directory. The source is streamed as a tar archive from a helper
+// container created from image and extracted by drmake itself, so the
+// copied files are owned by the invoking user.
+//
+// A plain source path is copied like cp -R. If the source is a glob, every
+// match is copied into the destination directory, and matches of patterns
+// containing ** (which match any number of directories) are copied as
+// individual files.
+//
+// The copied files are recorded in res for the artifact manifest.
+func (r *Runner) copyArtifact(a parser.Artifact, image string, res *Result) error {
+	if IsUpload(a.Dst) {
+		return r.uploadArtifact(a, image, res)
+	}
+	files, err := r.copyArtifactTo(a, image, filepath.Join(r.Dir, filepath.FromSlash(a.Dst)))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 && a.Dst != "-" {
+		log.Printf("Artifact %s does not exist or matched no files, nothing was copied\n", a.Src)
+	}
+	for _, file := range files {
+		rel, err := filepath.Rel(r.Dir, file)
+		if err != nil {
+			rel = file
+		}
+		if err := r.recordArtifact(res, file, filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyArtifactTo copies an artifact out of the workspace volume (or for
+// ARTIFACT IMAGE, the image) to dst and returns the paths of the files it
+// wrote.
+func (r *Runner) copyArtifactTo(a parser.Artifact, image, dst string) ([]string, error) {
+	base := "/work"
+	src := path.Clean(a.Src)
+	if a.Image {
+		base = "/"
+		src = strings.TrimPrefix(src, "/")
+	}
+	root := globRoot(src)
+	if a.Dst == "-" && isGlob(src) {
+		return nil, fmt.Errorf("only a single file can be streamed to stdout")
+	}
+	if r.Host && !a.Image {
+		return r.copyHostArtifact(a, dst, src, root)
+	}
+
+	id, err := r.createHelper(image)
+	if err != nil {
+		return nil, err
+	}
+	defer r.removeHelper(id)
+
+	cmd := r.rt.Command("cp", id+":"+path.Join(base, root), "-")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var files []string
+	var extractErr error
+	if a.Dst == "-" {
+		extractErr = streamArtifact(out, os.Stdout)
+	} else {
+		files, extractErr = extractArtifact(out, dst, a, src, root, r.ArtifactTime)
+	}
+	io.Copy(ioutil.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+	return files, extractErr
+}
+
+// copyHostArtifact copies an artifact out of the project directory, which is
+// the workspace in host mode, to dst. It is archived and extracted the same
+// way as artifacts in the workspace volume, so that they are copied alike.
+func (r *Runner) copyHostArtifact(a parser.Artifact, dst, src, root string) ([]string, error) {
+	hostRoot := filepath.Join(r.Dir, filepath.FromSlash(root))
+	if _, err := os.Stat(hostRoot); err != nil {
+		if a.Dst == "-" {
+			return nil, fmt.Errorf("artifact not found")
+		}
+		return nil, nil
+	}
+	if !isGlob(src) && a.Dst != "-" && filepath.Clean(dst) == hostRoot {
+		// The artifact is already in place.
+		files := []string{}
+		err := filepath.Walk(hostRoot, func(name string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				files = append(files, name)
+			}
+			return err
+		})
+		return files, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		// Only the name of the first path component is replaced by root
+		// when extracting, so any name will do.
+		pw.CloseWithError(writeTar(pw, hostRoot, "work"))
+	}()
+	defer pr.Close()
+	if a.Dst == "-" {
+		return nil, streamArtifact(pr, os.Stdout)
+	}
+	return extractArtifact(pr, dst, a, src, root, r.ArtifactTime)
+}
+
+// globRoot returns the leading directories of src that contain no glob
+// characters.
+func globRoot(src string) string {
+	if !isGlob(src) {
+		return src
+	}
+	parts := strings.Split(src, "/")
+	for i, part := range parts {
+		if isGlob(part) {
+			return path.Join(append([]string{"."}, parts[:i]...)...)
+		}
+	}
+	return src
+}
+
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// extractArtifact extracts the tar stream of root (a directory or file in
+// the workspace volume) into dst, the artifact's destination on the host,
+// and returns the paths of the files it wrote. Modes, symlinks, hard links and modification times are preserved, unless
+// mtime is set, in which case every file is given that time instead.
+func extractArtifact(r io.Reader, dst string, a parser.Artifact, src, root string, mtime time.Time) ([]string, error) {
+	intoDir := strings.HasSuffix(a.Dst, "/") || isGlob(src)
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		intoDir = true
+	}
+
+	var reRecursive *regexp.Regexp
+	if strings.Contains(src, "**") {
+		reRecursive = globRegexp(src)
+	}
+	exclude := IgnoreList(a.Exclude)
+
+	// target maps a path in the workspace volume to its host destination,
+	// or returns an empty string if it is not part of the artifact.
+	target := func(rel string) string {
+		if exclude.Match(strings.TrimPrefix(strings.TrimPrefix(rel, root), "/")) {
+			return ""
+		}
+		switch {
+		case reRecursive != nil:
+			if reRecursive.MatchString(rel) {
+				return filepath.Join(dst, path.Base(rel))
+			}
+		case isGlob(src):
+			parts := strings.Split(rel, "/")
+			n := len(strings.Split(src, "/"))
+			if len(parts) < n {
+				return ""
+			}
+			prefix := strings.Join(parts[:n], "/")
+			if ok, _ := path.Match(src, prefix); ok {
+				return filepath.Join(dst, path.Base(prefix), filepath.FromSlash(strings.Join(parts[n:], "/")))
+			}
+		default:
+			base := dst
+			if intoDir {
+				base = filepath.Join(dst, path.Base(src))
+			}
+			return filepath.Join(base, filepath.FromSlash(strings.TrimPrefix(rel, src)))
+		}
+		return ""
+	}
+
+	// Entries are named relative to the parent of root, so replace the
+	// first path component with root itself.
+	relName := func(name string) (string, error) {
+		clean := strings.Trim(path.Clean(name), "/")
+		if strings.HasPrefix(clean, "../") {
+			return "", fmt.Errorf("invalid path in archive: %s", name)
+		}
+		if i := strings.Index(clean, "/"); i >= 0 {
+			return path.Join(root, clean[i+1:]), nil
+		}
+		return root, nil
+	}
+
+	// Directories are given their mode and time once everything in them
+	// has been written, since writing their contents changes both.
+	var dirs []*tar.Header
+	var dirDests []string
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			os.Chmod(dirDests[i], entryMode(dirs[i]))
+			setTime(dirDests[i], dirs[i], mtime)
+		}
+	}()
+
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rel, err := relName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if reRecursive != nil && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		dest := target(rel)
+		if dest == "" {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			// Hard links name the earlier entry they link to, which must
+			// be part of the artifact too.
+			linked, err := relName(hdr.Linkname)
+			if err != nil {
+				return nil, err
+			}
+			if hdr.Linkname = target(linked); hdr.Linkname == "" {
+				continue
+			}
+		}
+		if err := writeEntry(tr, hdr, dest); err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			dirs = append(dirs, hdr)
+			dirDests = append(dirDests, dest)
+		case tar.TypeReg, tar.TypeRegA:
+			setTime(dest, hdr, mtime)
+			files = append(files, dest)
+		}
+	}
+}
+
+// streamArtifact writes the contents of the single file in the tar stream r
+// to w.
+func streamArtifact(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return fmt.Errorf("artifact not found")
+	} else if err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return fmt.Errorf("only a single file can be streamed to stdout")
+	}
+	_, err = io.Copy(w, tr)
+	return err
+}
+
+// entryMode returns the permissions of a tar entry, including the setuid,
+// setgid and sticky bits.
+func entryMode(hdr *tar.Header) os.FileMode {
+	return hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// setTime sets the modification time of dest to that of its tar entry, or to
+// mtime if set.
+func setTime(dest string, hdr *tar.Header, mtime time.Time) error {
+	if mtime.IsZero() {
+		mtime = hdr.ModTime
+	}
+	return os.Chtimes(dest, mtime, mtime)
+}
+
+// writeEntry writes a single tar entry to dest.
+func writeEntry(tr *tar.Reader, hdr *tar.Header, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0775); err != nil {
+		return err
+	}
+	mode := entryMode(hdr)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(dest, mode.Perm()|0700)
+	case tar.TypeSymlink:
+		os.Remove(dest)
+		return os.Symlink(hdr.Linkname, dest)
+	case tar.TypeLink:
+		os.Remove(dest)
+		return os.Link(hdr.Linkname, dest)
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Chmod(dest, mode)
+	}
+	return nil
+}
+
+// globRegexp converts a glob pattern in which ** matches any number of
+// directories into a regular expression.
+func globRegexp(pattern string) *regexp.Regexp {
+	re := ""
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re += "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re += ".*"
+			i++
+		case c == '*':
+			re += "[^/]*"
+		case c == '?':
+			re += "[^/]"
+		case c == '[' && strings.Contains(pattern[i:], "]"):
+			end := i + strings.Index(pattern[i:], "]")
+			class := pattern[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re += "[" + class + "]"
+			i = end
+		default:
+			re += regexp.QuoteMeta(string(c))
+		}
+	}
+	return regexp.MustCompile("^" + re + "$")
+}
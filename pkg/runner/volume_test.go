@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lsegal/drmake/pkg/parser"
+)
+
+// buildArchive returns a tar stream of the build directory as docker cp
+// writes it.
+func buildArchive(t *testing.T, names ...string) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg, Size: int64(len(name))}
+		if name[len(name)-1] == '/' {
+			hdr.Mode, hdr.Typeflag, hdr.Size = 0755, tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestExtractArtifact(t *testing.T) {
+	entries := []string{"build/", "build/a.txt", "build/sub/", "build/sub/b.deb", "build/sub/c.txt"}
+	tests := []struct {
+		name    string
+		src     string
+		dst     string
+		exclude []string
+		want    []string
+	}{
+		{"directory", "build", "out", nil, []string{"out/a.txt", "out/sub/b.deb", "out/sub/c.txt"}},
+		{"into directory", "build", "out/", nil, []string{"out/build/a.txt", "out/build/sub/b.deb", "out/build/sub/c.txt"}},
+		{"glob", "build/*.txt", "out", nil, []string{"out/a.txt"}},
+		{"glob directories", "build/s*", "out", nil, []string{"out/sub/b.deb", "out/sub/c.txt"}},
+		{"recursive glob", "build/**/*.txt", "out", nil, []string{"out/a.txt", "out/c.txt"}},
+		{"exclude", "build", "out", []string{"*.deb"}, []string{"out/a.txt", "out/sub/c.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "drmake-artifact")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			a := parser.Artifact{Src: tt.src, Dst: tt.dst, Exclude: tt.exclude}
+			dst := filepath.Join(dir, filepath.FromSlash(tt.dst))
+			files, err := extractArtifact(buildArchive(t, entries...), dst, a, tt.src, globRoot(tt.src), time.Time{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := []string{}
+			for _, f := range files {
+				rel, _ := filepath.Rel(dir, f)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got files %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractArtifactInvalidPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drmake-artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := parser.Artifact{Src: "build", Dst: "out"}
+	_, err = extractArtifact(buildArchive(t, "build/../../evil"), filepath.Join(dir, "out"), a, "build", "build", time.Time{})
+	if err == nil {
+		t.Fatal("expected an error for a path outside the artifact")
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"build/**/*.txt", "build/a.txt", true},
+		{"build/**/*.txt", "build/sub/deep/c.txt", true},
+		{"build/**/*.txt", "build/a.deb", false},
+		{"build/**/*.txt", "other/a.txt", false},
+		{"build/**", "build/sub/b.deb", true},
+		{"build/*.txt", "build/sub/c.txt", false},
+		{"build/?.txt", "build/a.txt", true},
+		{"build/?.txt", "build/ab.txt", false},
+		{"build/[ab].txt", "build/b.txt", true},
+		{"build/[!ab].txt", "build/b.txt", false},
+		{"build/a+b.txt", "build/a+b.txt", true},
+		{"build/a+b.txt", "build/aab.txt", false},
+	}
+	for _, tt := range tests {
+		if got := globRegexp(tt.pattern).MatchString(tt.name); got != tt.match {
+			t.Errorf("globRegexp(%q) matching %q = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}